@@ -0,0 +1,83 @@
+// Package auth provides authenticators for the proxy.
+package auth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"httpproxy/lib"
+)
+
+// HTTPAuthenticator asks an HTTP endpoint, in the manner of nginx's
+// auth_request. The credentials are POSTed as JSON; a 2xx answer allows
+// them, 401 or 403 denies them and anything else is an error.
+// Decisions are cached for ttl.
+type HTTPAuthenticator struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]decision
+}
+
+type decision struct {
+	allow   bool
+	expires time.Time
+}
+
+func NewHTTPAuthenticator(url string, ttl, timeout time.Duration) *HTTPAuthenticator {
+	return &HTTPAuthenticator{
+		url:    url,
+		ttl:    ttl,
+		client: &http.Client{Timeout: timeout},
+		cache:  make(map[[sha256.Size]byte]decision),
+	}
+}
+
+func (a *HTTPAuthenticator) Authenticate(c lib.Credentials) (bool, error) {
+	body, err := json.Marshal(c)
+	if err != nil {
+		return false, err
+	}
+	key := sha256.Sum256(body)
+
+	now := time.Now()
+	a.mu.Lock()
+	d, ok := a.cache[key]
+	a.mu.Unlock()
+	if ok && now.Before(d.expires) {
+		return d.allow, nil
+	}
+
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	var allow bool
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		allow = true
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		allow = false
+	default:
+		return false, fmt.Errorf("auth request %s answered %s", a.url, resp.Status)
+	}
+
+	a.mu.Lock()
+	for k, d := range a.cache {
+		if now.After(d.expires) {
+			delete(a.cache, k)
+		}
+	}
+	a.cache[key] = decision{allow, now.Add(a.ttl)}
+	a.mu.Unlock()
+	return allow, nil
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"httpproxy/lib"
+)
+
+// authServer answers with the status set for the password, and counts
+// the requests it got.
+func authServer(t *testing.T, statuses map[string]int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls.Add(1)
+		var c lib.Credentials
+		if req.Method != "POST" || json.NewDecoder(req.Body).Decode(&c) != nil || c.User != "alice" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		rw.WriteHeader(statuses[c.Password])
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func TestHTTPAuthenticator(t *testing.T) {
+	srv, calls := authServer(t, map[string]int{
+		"ok":        http.StatusNoContent,
+		"wrong":     http.StatusUnauthorized,
+		"forbidden": http.StatusForbidden,
+		"broken":    http.StatusInternalServerError,
+	})
+	a := NewHTTPAuthenticator(srv.URL, time.Minute, time.Second)
+	tests := []struct {
+		password string
+		allow    bool
+		err      bool
+		calls    int32 // requests sent so far
+	}{
+		{"ok", true, false, 1},
+		{"ok", true, false, 1}, // cached
+		{"wrong", false, false, 2},
+		{"wrong", false, false, 2},
+		{"forbidden", false, false, 3},
+		{"broken", false, true, 4},
+		{"broken", false, true, 5}, // errors are not cached
+	}
+	for _, tt := range tests {
+		allow, err := a.Authenticate(lib.Credentials{User: "alice", Password: tt.password, ClientIP: "192.0.2.1"})
+		if allow != tt.allow || (err != nil) != tt.err {
+			t.Errorf("password %q: got %v, %v, want %v, error %v", tt.password, allow, err, tt.allow, tt.err)
+		}
+		if n := calls.Load(); n != tt.calls {
+			t.Errorf("password %q: %d requests sent, want %d", tt.password, n, tt.calls)
+		}
+	}
+}
+
+func TestHTTPAuthenticatorExpiry(t *testing.T) {
+	srv, calls := authServer(t, map[string]int{"ok": http.StatusOK})
+	a := NewHTTPAuthenticator(srv.URL, 50*time.Millisecond, time.Second)
+	c := lib.Credentials{User: "alice", Password: "ok"}
+	for i, wait := range []time.Duration{0, 0, 100 * time.Millisecond} {
+		time.Sleep(wait)
+		if allow, err := a.Authenticate(c); !allow || err != nil {
+			t.Fatalf("attempt %d: %v, %v", i, allow, err)
+		}
+	}
+	// Other credentials, like another client IP, are asked about anew.
+	c.ClientIP = "192.0.2.1"
+	a.Authenticate(c)
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d requests sent, want 3", n)
+	}
+}
+
+func TestHTTPAuthenticatorUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+	a := NewHTTPAuthenticator(url, time.Minute, time.Second)
+	if allow, err := a.Authenticate(lib.Credentials{User: "alice"}); allow || err == nil {
+		t.Errorf("got %v, %v, want an error", allow, err)
+	}
+}
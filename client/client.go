@@ -279,6 +279,12 @@ func (p *HTTPProxyClient) RoundTrip(req *http.Request) (*http.Response, error) {
 			log.Error(err)
 			return nil, err
 		}
+		// The connection now speaks another protocol,
+		// hand it over instead of putting it back.
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			resp.Body = &bufferedConn{c, reader}
+			return resp, nil
+		}
 		// New body
 		b := &body{
 			src: resp.Body,
@@ -318,6 +324,10 @@ func (p *HTTPProxyClient) RoundTrip(req *http.Request) (*http.Response, error) {
 			log.Error(err)
 			return nil, err
 		}
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			resp.Body = &bufferedConn{tlsConn, reader}
+			return resp, nil
+		}
 		// New body
 		b := &body{
 			src: resp.Body,
@@ -1,10 +1,12 @@
 package client
 
 import (
+	"bufio"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -99,6 +101,33 @@ func ClearHeaders(headers http.Header) {
 	}
 }
 
+// IsUpgrade reports whether req asks to switch protocols,
+// e.g. a WebSocket or h2c handshake.
+func IsUpgrade(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range req.Header["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bufferedConn is a connection whose reads go through a bufio.Reader
+// which may already hold data read from it.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
 // RmProxyHeaders remove Hop-by-hop headers.
 func RmProxyHeaders(req *http.Request) {
 	req.RequestURI = ""
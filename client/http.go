@@ -3,6 +3,7 @@ package client
 import (
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"time"
 )
@@ -54,8 +55,17 @@ func (proxy *entryHTTPHandler) ServeHTTP(rw http.ResponseWriter, req *http.Reque
 //处理普通的http请求
 func (proxy *entryHTTPHandler) HTTPHandler(rw http.ResponseWriter, req *http.Request) {
 	log.Debugf("HTTP Entry: Sending request %s %s", req.Method, req.Host)
+	upgrade := ""
+	if IsUpgrade(req) {
+		upgrade = req.Header.Get("Upgrade")
+	}
 	SanitizeRequest(req)
 	RmProxyHeaders(req)
+	if upgrade != "" {
+		// Keep the handshake which RmProxyHeaders removed.
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", upgrade)
+	}
 
 	resp, err := proxy.tr.RoundTrip(req)
 	if err != nil {
@@ -63,6 +73,10 @@ func (proxy *entryHTTPHandler) HTTPHandler(rw http.ResponseWriter, req *http.Req
 		http.Error(rw, err.Error(), 500)
 		return
 	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		proxy.switchProtocols(rw, req, resp)
+		return
+	}
 	defer resp.Body.Close()
 
 	ClearHeaders(rw.Header())
@@ -78,6 +92,37 @@ func (proxy *entryHTTPHandler) HTTPHandler(rw http.ResponseWriter, req *http.Req
 	log.Infof("HTTP Entry: copied %d bytes from %s", nr, req.URL.Host)
 }
 
+// switchProtocols relays a 101 response and splices the client
+// with the upgraded connection to the proxy.
+func (proxy *entryHTTPHandler) switchProtocols(rw http.ResponseWriter, req *http.Request, resp *http.Response) {
+	remote, ok := resp.Body.(net.Conn)
+	if !ok {
+		resp.Body.Close()
+		http.Error(rw, "HTTP Entry: upgraded response without connection", http.StatusBadGateway)
+		return
+	}
+	hj, _ := rw.(http.Hijacker)
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		log.Errorf("HTTP Entry: Failed to get TCP connection of %s", req.RequestURI)
+		remote.Close()
+		return
+	}
+	brw.WriteString("HTTP/1.1 " + resp.Status + "\r\n")
+	resp.Header.Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		remote.Close()
+		return
+	}
+	log.Infof("HTTP Entry: switched %s to %s", req.URL.Host, resp.Header.Get("Upgrade"))
+	client := &bufferedConn{conn, brw.Reader}
+	ch := make(chan bool, 1)
+	go CopyIO(remote, client, ch)
+	go CopyIO(client, remote, ch)
+}
+
 var http200 = []byte("HTTP/1.1 200 Connection Established\r\n\r\n")
 // HTTPSHandler handles any connection which need connect method.
 // 处理https连接，主要用于CONNECT方法
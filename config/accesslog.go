@@ -0,0 +1,11 @@
+package config
+
+// AccessLog 访问日志配置，每个请求或隧道一条记录
+type AccessLog struct {
+	// 日志文件路径，为空则不记录，"-" 为标准输出
+	Path string `json:"path"`
+
+	// 格式: "json"(默认)、"combined"(Apache 组合格式)，
+	// 其他值作为 text/template 模板，如"{{.Time}} {{.User}} {{.Target}} {{.Status}}"
+	Format string `json:"format"`
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ACL 按用户和用户组限制可访问的目标
+type ACL struct {
+	// 用户组，组名到用户名列表，规则中以"@组名"引用
+	Groups map[string][]string `json:"groups"`
+
+	// 规则，按顺序匹配，第一条命中的规则生效
+	Rules []ACLRule `json:"rules"`
+
+	// 没有规则命中时的动作: "allow"(默认) 或 "deny"
+	Default string `json:"default"`
+}
+
+// ACLRule 访问控制规则，各条件同时满足才命中，为空的条件不限制
+type ACLRule struct {
+	// 规则名，拒绝时写入日志
+	Name string `json:"name"`
+
+	// 动作: "allow" 或 "deny"
+	Action string `json:"action"`
+
+	// 适用的用户名或"@组名"，为空则适用于所有用户
+	Users []string `json:"users"`
+
+	// 目标域名(含子域名)，"*" 匹配所有；与 cidrs 任一命中即可
+	Domains []string `json:"domains"`
+
+	// 目标 IP 段，如"10.0.0.0/8"
+	CIDRs []string `json:"cidrs"`
+
+	// 目标端口或端口范围，如"443"、"8000-9000"
+	Ports []string `json:"ports"`
+
+	// HTTP 方法，如"GET"、"CONNECT"
+	Methods []string `json:"methods"`
+
+	// 规则生效的时间，日程表达式，为空则一直生效
+	Schedule string `json:"schedule"`
+}
+
+// ParsePortRange parses "443" or "8000-9000".
+func ParsePortRange(s string) (lo, hi int, err error) {
+	from, to := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		from, to = s[:i], s[i+1:]
+	}
+	if lo, err = strconv.Atoi(strings.TrimSpace(from)); err != nil {
+		return 0, 0, fmt.Errorf("bad port range %q", s)
+	}
+	if hi, err = strconv.Atoi(strings.TrimSpace(to)); err != nil {
+		return 0, 0, fmt.Errorf("bad port range %q", s)
+	}
+	if lo < 0 || hi > 65535 || lo > hi {
+		return 0, 0, fmt.Errorf("bad port range %q", s)
+	}
+	return lo, hi, nil
+}
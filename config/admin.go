@@ -0,0 +1,33 @@
+package config
+
+// web管理角色，权限依次增加
+const (
+	// 只读，可查看所有页面
+	RoleViewer = "viewer"
+	// 可管理代理用户、令牌和封禁，不能修改设置
+	RoleUserManager = "user_manager"
+	// 全部权限
+	RoleAdmin = "admin"
+)
+
+// roleRank orders the roles, unknown roles rank lowest.
+var roleRank = map[string]int{RoleViewer: 1, RoleUserManager: 2, RoleAdmin: 3}
+
+// Admin web管理账号
+type Admin struct {
+	// 密码，明文加载时自动转换为哈希
+	Password string `json:"password"`
+
+	// 角色: "viewer"、"user_manager"、"admin"
+	Role string `json:"role"`
+}
+
+// Can reports whether the role has at least the rights of role.
+func (a Admin) Can(role string) bool {
+	return roleRank[a.Role] > 0 && roleRank[a.Role] >= roleRank[role]
+}
+
+// ValidRole reports whether role is known.
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
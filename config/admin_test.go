@@ -0,0 +1,27 @@
+package config
+
+import "testing"
+
+func TestAdminCan(t *testing.T) {
+	tests := []struct {
+		role, need string
+		want       bool
+	}{
+		{RoleViewer, RoleViewer, true},
+		{RoleViewer, RoleUserManager, false},
+		{RoleViewer, RoleAdmin, false},
+		{RoleUserManager, RoleViewer, true},
+		{RoleUserManager, RoleUserManager, true},
+		{RoleUserManager, RoleAdmin, false},
+		{RoleAdmin, RoleAdmin, true},
+		{RoleAdmin, RoleViewer, true},
+		{"", RoleViewer, false},
+		{"root", RoleViewer, false},
+		{"root", "root", false},
+	}
+	for _, tt := range tests {
+		if got := (Admin{Role: tt.role}).Can(tt.need); got != tt.want {
+			t.Errorf("%q can %q: got %v, want %v", tt.role, tt.need, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package config
+
+// AuthRequest 外部认证服务，设置 url 后代理认证交给该服务：
+// 以 JSON POST 用户名、密码、客户端IP和目标地址，2xx 为允许，401/403 为拒绝
+type AuthRequest struct {
+	// 认证服务地址，如"http://127.0.0.1:9000/auth"，为空则使用 users
+	URL string `json:"url"`
+
+	// 结果缓存时间，单位秒，默认60
+	TTL int `json:"ttl"`
+
+	// 请求超时，单位秒，默认5
+	Timeout int `json:"timeout"`
+}
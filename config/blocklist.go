@@ -0,0 +1,20 @@
+package config
+
+// Blocklist 订阅的屏蔽列表，定期更新后并入 gfwlist 规则
+type Blocklist struct {
+	// 名称，用于日志、缓存文件名和web管理界面
+	Name string `json:"name"`
+
+	// 地址，http(s) URL 或本地文件路径
+	URL string `json:"url"`
+
+	// 格式: "hosts"(hosts文件)、"domains"(每行一个域名)、"adblock"(Adblock/gfwlist规则)、
+	// "gfwlist"(base64编码的gfwlist)、"cidr"(每行一个IP段或IP)
+	Format string `json:"format"`
+
+	// 更新间隔，单位分钟，默认1440
+	Interval int `json:"interval"`
+
+	// 为 true 时列表中的条目为放行规则
+	Allow bool `json:"allow"`
+}
@@ -0,0 +1,19 @@
+package config
+
+// Camouflage 伪装网站，没有通过代理认证的请求看到的是一个普通网站
+type Camouflage struct {
+	// 开启伪装，开启后认证失败的请求不再返回407，也不发送到 failover
+	Enable bool `json:"enable"`
+
+	// 网站目录，为空则显示 nginx 默认页面
+	Root string `json:"root"`
+
+	// 为 true 时目录中的 .html 文件作为 html/template 模板渲染
+	Templates bool `json:"templates"`
+
+	// Server 响应头，默认"nginx"
+	Server string `json:"server"`
+
+	// 访问该域名时仍返回407，供不会主动发送认证信息的客户端(如浏览器、Digest)登入
+	ChallengeHost string `json:"challenge_host"`
+}
@@ -0,0 +1,213 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// Load reads and checks the config file at filename. Passwords it hashes
+// are only written back by SaveRehashed.
+// Load 读取并检查配置文件
+func Load(filename string) (*Config, error) {
+	c := new(Config)
+	if err := c.SetPath(filename); err != nil {
+		return nil, err
+	}
+	if err := c.GetConfig(); err != nil {
+		return nil, err
+	}
+	if err := c.Check(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Path returns the file the config was read from.
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Check reports the first mistake found in the config.
+// Check 检查配置是否有误
+func (c *Config) Check() error {
+	for name, addr := range map[string]string{"listen": c.Listen, "weblisten": c.WebListen} {
+		if _, err := url.Parse(addr); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	switch c.PasswordHash {
+	case "", "bcrypt", "scrypt", "argon2id":
+	default:
+		return fmt.Errorf("password_hash: unknown algorithm %q", c.PasswordHash)
+	}
+	for name, a := range c.Admins {
+		if !ValidRole(a.Role) {
+			return fmt.Errorf("admin %s: unknown role %q", name, a.Role)
+		}
+	}
+	for _, s := range c.AuthSchemes {
+		if s != "basic" && s != "digest" {
+			return fmt.Errorf("auth_schemes: unknown scheme %q", s)
+		}
+	}
+	switch c.UserFile.Format {
+	case "", "htpasswd", "json", "csv":
+	default:
+		return fmt.Errorf("user_file: unknown format %q", c.UserFile.Format)
+	}
+	for name, u := range c.Upstreams {
+		switch u.Strategy {
+		case "", "round_robin", "least_conn", "weighted", "hash":
+		default:
+			return fmt.Errorf("upstream %s: unknown strategy %q", name, u.Strategy)
+		}
+		if len(u.Servers) == 0 {
+			return fmt.Errorf("upstream %s: no servers", name)
+		}
+	}
+	for i, r := range c.Routes {
+		if r.Pass == "" {
+			return fmt.Errorf("route %d: no pass", i)
+		}
+		if r.PathRegex != "" {
+			if _, err := regexp.Compile(r.PathRegex); err != nil {
+				return fmt.Errorf("route %d: %v", i, err)
+			}
+		}
+	}
+	for name, raw := range c.Parents {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return fmt.Errorf("parent %s: %v", name, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("parent %s: unsupported scheme %q", name, u.Scheme)
+		}
+	}
+	for i, r := range c.ParentRules {
+		for _, cidr := range r.CIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("parent rule %d: %v", i, err)
+			}
+		}
+		for _, name := range r.Parents {
+			if _, ok := c.Parents[name]; !ok && name != "direct" {
+				return fmt.Errorf("parent rule %d: unknown parent %s", i, name)
+			}
+		}
+	}
+	switch c.ACL.Default {
+	case "", "allow", "deny":
+	default:
+		return fmt.Errorf("acl: unknown default %q", c.ACL.Default)
+	}
+	for i, r := range c.ACL.Rules {
+		if r.Action != "allow" && r.Action != "deny" {
+			return fmt.Errorf("acl rule %d: unknown action %q", i, r.Action)
+		}
+		for _, cidr := range r.CIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("acl rule %d: %v", i, err)
+			}
+		}
+		for _, p := range r.Ports {
+			if _, _, err := ParsePortRange(p); err != nil {
+				return fmt.Errorf("acl rule %d: %v", i, err)
+			}
+		}
+		for _, u := range r.Users {
+			if strings.HasPrefix(u, "@") {
+				if _, ok := c.ACL.Groups[u[1:]]; !ok {
+					return fmt.Errorf("acl rule %d: unknown group %s", i, u)
+				}
+			}
+		}
+	}
+	for _, cidr := range append(append([]string{}, c.Egress.Allow...), c.Egress.Deny...) {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("egress: %v", err)
+		}
+	}
+	for _, p := range append(append([]string{}, c.Egress.Ports...), c.Egress.ConnectPorts...) {
+		if _, _, err := ParsePortRange(p); err != nil {
+			return fmt.Errorf("egress: %v", err)
+		}
+	}
+	for user, p := range c.Profiles {
+		if p.Schedule == "" {
+			continue
+		}
+		if _, err := ParseSchedule(p.Schedule); err != nil {
+			return fmt.Errorf("profile %s: %v", user, err)
+		}
+	}
+	for i, r := range c.ACL.Rules {
+		if r.Schedule == "" {
+			continue
+		}
+		if _, err := ParseSchedule(r.Schedule); err != nil {
+			return fmt.Errorf("acl rule %d: %v", i, err)
+		}
+	}
+	names := make(map[string]bool)
+	for i, l := range c.Blocklists {
+		if l.Name == "" || strings.ContainsAny(l.Name, `/\`) || names[l.Name] {
+			return fmt.Errorf("blocklist %d: missing, duplicate or bad name %q", i, l.Name)
+		}
+		names[l.Name] = true
+		if l.URL == "" {
+			return fmt.Errorf("blocklist %s: url is empty", l.Name)
+		}
+		switch l.Format {
+		case "hosts", "domains", "adblock", "gfwlist", "cidr":
+		default:
+			return fmt.Errorf("blocklist %s: unknown format %q", l.Name, l.Format)
+		}
+	}
+	for _, s := range c.GFWList {
+		s = strings.TrimPrefix(strings.TrimSpace(s), "@@")
+		var err error
+		if i := strings.LastIndex(s, "$schedule="); i >= 0 {
+			if _, err = ParseSchedule(s[i+len("$schedule="):]); err != nil {
+				return fmt.Errorf("gfwlist rule %s: %v", s, err)
+			}
+			s = s[:i]
+		}
+		switch {
+		case strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") && len(s) > 1:
+			_, err = regexp.Compile(s[1 : len(s)-1])
+		case strings.HasPrefix(s, ":"):
+			_, _, err = ParsePortRange(s[1:])
+		case strings.Contains(s, "/") && net.ParseIP(s[:strings.Index(s, "/")]) != nil:
+			_, _, err = net.ParseCIDR(s)
+		}
+		if err != nil {
+			return fmt.Errorf("gfwlist rule %s: %v", s, err)
+		}
+	}
+	switch c.AccessLog.Format {
+	case "", "json", "combined":
+	default:
+		if _, err := template.New("access").Parse(c.AccessLog.Format); err != nil {
+			return fmt.Errorf("access_log: %v", err)
+		}
+	}
+	if c.Camouflage.Enable && c.Camouflage.Root != "" {
+		if fi, err := os.Stat(c.Camouflage.Root); err != nil {
+			return fmt.Errorf("camouflage: %v", err)
+		} else if !fi.IsDir() {
+			return fmt.Errorf("camouflage: %s is not a directory", c.Camouflage.Root)
+		}
+	}
+	if c.Metrics.User != "" && c.Metrics.Password == "" {
+		return fmt.Errorf("metrics: user %s has no password", c.Metrics.User)
+	}
+	return nil
+}
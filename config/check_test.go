@@ -0,0 +1,22 @@
+package config
+
+import "testing"
+
+func TestCheckMetrics(t *testing.T) {
+	tests := []struct {
+		m  Metrics
+		ok bool
+	}{
+		{Metrics{Enable: true}, true},
+		{Metrics{Enable: true, User: "prom", Password: "secret"}, true},
+		{Metrics{Enable: true, Token: "t0ken"}, true},
+		{Metrics{Enable: true, User: "prom"}, false},
+		{Metrics{Enable: true, User: "prom", Token: "t0ken"}, false},
+	}
+	for _, tt := range tests {
+		c := Config{Metrics: tt.m}
+		if err := c.Check(); (err == nil) != tt.ok {
+			t.Errorf("%+v: err = %v, want ok %v", tt.m, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// DigestRealm is the realm of the proxy's challenges. Stored digests
+// depend on it, so changing it invalidates them.
+const DigestRealm = "Secure Proxys"
+
+// Digest 用户的 Digest 认证摘要 H(用户名:realm:密码)，设置密码时自动生成。
+// 与 users 中的密码哈希不同，它无需破解即可用于 Digest 认证，
+// 泄露等同于泄露密码
+type Digest struct {
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5"`
+}
+
+// NewDigest computes the digests of user's password.
+func NewDigest(user, passwd string) Digest {
+	a1 := []byte(user + ":" + DigestRealm + ":" + passwd)
+	s := sha256.Sum256(a1)
+	m := md5.Sum(a1)
+	return Digest{SHA256: hex.EncodeToString(s[:]), MD5: hex.EncodeToString(m[:])}
+}
+
+// DigestEnabled reports whether auth_schemes offers Digest.
+func (c *Config) DigestEnabled() bool {
+	return c.schemeEnabled("digest")
+}
+
+// BasicEnabled reports whether auth_schemes offers Basic, the default.
+func (c *Config) BasicEnabled() bool {
+	return len(c.AuthSchemes) == 0 || c.schemeEnabled("basic")
+}
+
+func (c *Config) schemeEnabled(scheme string) bool {
+	for _, s := range c.AuthSchemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPassword stores the hash of user's password, and its digests when
+// Digest authentication is enabled. Otherwise the digests of the old
+// password are dropped, so they cannot be used once Digest is enabled.
+func (c *Config) SetPassword(user, passwd string) error {
+	hash, err := HashPassword(passwd, c.PasswordHash)
+	if err != nil {
+		return err
+	}
+	if c.User == nil {
+		c.User = make(map[string]string)
+	}
+	c.User[user] = hash
+	if c.DigestEnabled() {
+		if c.Digests == nil {
+			c.Digests = make(map[string]Digest)
+		}
+		c.Digests[user] = NewDigest(user, passwd)
+	} else {
+		delete(c.Digests, user)
+	}
+	return nil
+}
+
+// DeleteUser removes user and its digests.
+func (c *Config) DeleteUser(user string) {
+	delete(c.User, user)
+	delete(c.Digests, user)
+}
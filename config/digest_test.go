@@ -0,0 +1,45 @@
+package config
+
+import "testing"
+
+func TestSetPasswordDigests(t *testing.T) {
+	tests := []struct {
+		name    string
+		schemes []string
+		old     map[string]Digest
+		want    bool // whether alice has digests afterwards
+	}{
+		{"digest enabled", []string{"basic", "digest"}, nil, true},
+		{"digest disabled", []string{"basic"}, nil, false},
+		// The digests of the old password must not outlive it.
+		{"digest disabled, old digests", nil, map[string]Digest{"alice": NewDigest("alice", "old")}, false},
+	}
+	for _, tt := range tests {
+		c := &Config{AuthSchemes: tt.schemes, Digests: tt.old}
+		if err := c.SetPassword("alice", "new"); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		d, ok := c.Digests["alice"]
+		if ok != tt.want {
+			t.Errorf("%s: has digests = %v, want %v", tt.name, ok, tt.want)
+		}
+		if ok && d != NewDigest("alice", "new") {
+			t.Errorf("%s: digests are not of the new password", tt.name)
+		}
+		if !VerifyPassword(c.User["alice"], "new") {
+			t.Errorf("%s: password hash does not verify", tt.name)
+		}
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	c := &Config{AuthSchemes: []string{"digest"}}
+	c.SetPassword("alice", "secret")
+	c.DeleteUser("alice")
+	if _, ok := c.User["alice"]; ok {
+		t.Error("user is left")
+	}
+	if _, ok := c.Digests["alice"]; ok {
+		t.Error("digests are left")
+	}
+}
@@ -0,0 +1,19 @@
+package config
+
+// Egress 出站访问限制，防止通过代理访问本机和内网地址(SSRF)，默认开启
+type Egress struct {
+	// 关闭出站限制
+	Disable bool `json:"disable"`
+
+	// 允许的目标地址段，如["10.1.0.0/16"]，优先于禁止的地址段(包括默认禁止的内网地址)
+	Allow []string `json:"allow"`
+
+	// 额外禁止的目标地址段；本机、内网、链路本地等地址默认禁止
+	Deny []string `json:"deny"`
+
+	// 允许的目标端口，如["80","443","1024-65535"]，为空则不限
+	Ports []string `json:"ports"`
+
+	// CONNECT 允许的目标端口，如["443"]，为空则同 ports
+	ConnectPorts []string `json:"connect_ports"`
+}
@@ -0,0 +1,16 @@
+package config
+
+// Lockout 登录失败锁定，按客户端IP和用户名分别计数，代理和web管理登录都适用
+type Lockout struct {
+	// 失败多少次后锁定，0为不锁定
+	Threshold int `json:"threshold"`
+
+	// 首次锁定时长，单位秒，默认60；再次锁定时翻倍
+	Duration int `json:"duration"`
+
+	// 最长锁定时长，单位秒，默认3600
+	MaxDuration int `json:"max_duration"`
+
+	// 多久没有失败后清零计数，单位秒，默认900
+	Window int `json:"window"`
+}
@@ -0,0 +1,14 @@
+package config
+
+// Metrics /metrics 监控接口配置，认证与web管理分开
+type Metrics struct {
+	// 是否开启 /metrics
+	Enable bool `json:"enable"`
+
+	// Basic认证的用户名和密码，为空则不检查；设置了用户名时密码不能为空
+	User     string `json:"user"`
+	Password string `json:"password"`
+
+	// Bearer令牌，为空则不检查
+	Token string `json:"token"`
+}
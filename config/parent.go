@@ -0,0 +1,13 @@
+package config
+
+// ParentRule 上级代理选择规则，按顺序匹配，第一条匹配的规则生效
+type ParentRule struct {
+	// 匹配的目标域名(含子域名)，"*" 匹配所有
+	Domains []string `json:"domains"`
+
+	// 匹配的目标 IP 段，如"10.0.0.0/8"
+	CIDRs []string `json:"cidrs"`
+
+	// 按顺序尝试的上级代理名，"direct" 表示直连
+	Parents []string `json:"parents"`
+}
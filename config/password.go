@@ -0,0 +1,223 @@
+package config
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Hashes are stored as:
+//   bcrypt:   $2a$10$...
+//   scrypt:   $scrypt$ln=15,r=8,p=1$<salt>$<hash>
+//   argon2id: $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+// with salt and hash in unpadded base64. htpasswd's {SHA} and MD5-crypt
+// ($apr1$, $1$) hashes can be verified but are never generated.
+
+var b64 = base64.RawStdEncoding
+
+// IsHashed reports whether s is a password hash rather than plaintext.
+func IsHashed(s string) bool {
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") ||
+		strings.HasPrefix(s, "$2y$") || strings.HasPrefix(s, "$scrypt$") ||
+		strings.HasPrefix(s, "$argon2id$") || strings.HasPrefix(s, "{SHA}") ||
+		strings.HasPrefix(s, "$apr1$") || strings.HasPrefix(s, "$1$")
+}
+
+// HashPassword hashes passwd with algo: "bcrypt"(默认), "scrypt" 或 "argon2id".
+func HashPassword(passwd, algo string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	switch algo {
+	case "", "bcrypt":
+		h, err := bcrypt.GenerateFromPassword([]byte(passwd), bcrypt.DefaultCost)
+		return string(h), err
+	case "scrypt":
+		h, err := scrypt.Key([]byte(passwd), salt, 1<<15, 8, 1, 32)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("$scrypt$ln=15,r=8,p=1$%s$%s", b64.EncodeToString(salt), b64.EncodeToString(h)), nil
+	case "argon2id":
+		h := argon2.IDKey([]byte(passwd), salt, 3, 64*1024, 2, 32)
+		return fmt.Sprintf("$argon2id$v=%d$m=65536,t=3,p=2$%s$%s", argon2.Version,
+			b64.EncodeToString(salt), b64.EncodeToString(h)), nil
+	}
+	return "", fmt.Errorf("unknown password hash %q", algo)
+}
+
+// VerifyPassword reports whether passwd matches hash, in constant time.
+// A plaintext hash is compared as is.
+func VerifyPassword(hash, passwd string) bool {
+	if !IsHashed(hash) {
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(passwd)) == 1
+	}
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(passwd)) == nil
+	}
+	if strings.HasPrefix(hash, "{SHA}") {
+		sum := sha1.Sum([]byte(passwd))
+		got := base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(got), []byte(hash[len("{SHA}"):])) == 1
+	}
+	if strings.HasPrefix(hash, "$apr1$") || strings.HasPrefix(hash, "$1$") {
+		magic := hash[:strings.Index(hash[1:], "$")+2]
+		rest := hash[len(magic):]
+		i := strings.Index(rest, "$")
+		if i < 0 {
+			return false
+		}
+		got := md5Crypt([]byte(passwd), []byte(rest[:i]), []byte(magic))
+		return subtle.ConstantTimeCompare([]byte(got), []byte(hash)) == 1
+	}
+
+	// $name$params$salt$hash, argon2id has a version field before params
+	parts := strings.Split(hash, "$")
+	if len(parts) < 5 {
+		return false
+	}
+	salt, err1 := b64.DecodeString(parts[len(parts)-2])
+	want, err2 := b64.DecodeString(parts[len(parts)-1])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	params := parts[len(parts)-3]
+
+	var got []byte
+	switch parts[1] {
+	case "scrypt":
+		var ln, r, p int
+		if _, err := fmt.Sscanf(params, "ln=%d,r=%d,p=%d", &ln, &r, &p); err != nil {
+			return false
+		}
+		got, err1 = scrypt.Key([]byte(passwd), salt, 1<<uint(ln), r, p, len(want))
+		if err1 != nil {
+			return false
+		}
+	case "argon2id":
+		var m, t uint32
+		var p uint8
+		if _, err := fmt.Sscanf(params, "m=%d,t=%d,p=%d", &m, &t, &p); err != nil {
+			return false
+		}
+		got = argon2.IDKey([]byte(passwd), salt, t, m, p, uint32(len(want)))
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// md5Crypt computes an MD5-crypt hash, as used by htpasswd -m.
+func md5Crypt(passwd, salt, magic []byte) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	d := md5.New()
+	d.Write(passwd)
+	d.Write(magic)
+	d.Write(salt)
+
+	d2 := md5.New()
+	d2.Write(passwd)
+	d2.Write(salt)
+	d2.Write(passwd)
+	mixin := d2.Sum(nil)
+	for i := len(passwd); i > 0; i -= 16 {
+		if i > 16 {
+			d.Write(mixin)
+		} else {
+			d.Write(mixin[:i])
+		}
+	}
+	for i := len(passwd); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(passwd[:1])
+		}
+	}
+	final := d.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		d := md5.New()
+		if i&1 == 1 {
+			d.Write(passwd)
+		} else {
+			d.Write(final)
+		}
+		if i%3 != 0 {
+			d.Write(salt)
+		}
+		if i%7 != 0 {
+			d.Write(passwd)
+		}
+		if i&1 == 1 {
+			d.Write(final)
+		} else {
+			d.Write(passwd)
+		}
+		final = d.Sum(nil)
+	}
+
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	out := make([]byte, 0, 22)
+	enc := func(a, b, c byte, n int) {
+		v := uint(a)<<16 | uint(b)<<8 | uint(c)
+		for ; n > 0; n-- {
+			out = append(out, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	enc(final[0], final[6], final[12], 4)
+	enc(final[1], final[7], final[13], 4)
+	enc(final[2], final[8], final[14], 4)
+	enc(final[3], final[9], final[15], 4)
+	enc(final[4], final[10], final[5], 4)
+	enc(0, 0, final[11], 2)
+	return string(magic) + string(salt) + "$" + string(out)
+}
+
+// hashPasswords replaces the plaintext passwords of the config with
+// hashes. It reports whether anything changed.
+func (c *Config) hashPasswords() (bool, error) {
+	changed := false
+	if c.AdminPass != "" && !IsHashed(c.AdminPass) {
+		h, err := HashPassword(c.AdminPass, c.PasswordHash)
+		if err != nil {
+			return false, err
+		}
+		c.AdminPass = h
+		changed = true
+	}
+	for name, a := range c.Admins {
+		if a.Password == "" || IsHashed(a.Password) {
+			continue
+		}
+		h, err := HashPassword(a.Password, c.PasswordHash)
+		if err != nil {
+			return false, err
+		}
+		a.Password = h
+		c.Admins[name] = a
+		changed = true
+	}
+	for user, passwd := range c.User {
+		if IsHashed(passwd) {
+			continue
+		}
+		if err := c.SetPassword(user, passwd); err != nil {
+			return false, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
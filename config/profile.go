@@ -0,0 +1,60 @@
+package config
+
+// Profile 用户的附加设置，键"*"为默认值；用户的设置中未设置(为零)的
+// bandwidth、limits、schedule 沿用"*"的设置
+type Profile struct {
+	// 带宽限制
+	Bandwidth Bandwidth `json:"bandwidth"`
+
+	// 并发和请求频率限制
+	Limits Limits `json:"limits"`
+
+	// 允许使用代理的时间，日程表达式，如"mon-fri 09:00-18:00 Asia/Shanghai"，为空不限；
+	// 要不受"*"的日程限制可设为"00:00-24:00"
+	Schedule string `json:"schedule"`
+}
+
+// Bandwidth 带宽限制，单位字节每秒，0为不限制
+type Bandwidth struct {
+	// 上传速度
+	Upload int64 `json:"upload"`
+
+	// 下载速度
+	Download int64 `json:"download"`
+}
+
+// Limits 并发和请求频率限制，0为不限制
+type Limits struct {
+	// 最大并发隧道数(CONNECT 和协议升级)
+	Tunnels int64 `json:"tunnels"`
+
+	// 最大并发 HTTP 请求数
+	Requests int64 `json:"requests"`
+
+	// 每秒请求数
+	RPS float64 `json:"rps"`
+
+	// 允许的突发请求数，默认与 rps 相同
+	Burst int `json:"burst"`
+}
+
+// ProfileOf returns the profile of user. What the user's own profile
+// leaves unset comes from the "*" profile, so setting one thing for a
+// user does not drop the defaults of the others.
+func (c *Config) ProfileOf(user string) Profile {
+	def := c.Profiles["*"]
+	p, ok := c.Profiles[user]
+	if !ok {
+		return def
+	}
+	if p.Bandwidth == (Bandwidth{}) {
+		p.Bandwidth = def.Bandwidth
+	}
+	if p.Limits == (Limits{}) {
+		p.Limits = def.Limits
+	}
+	if p.Schedule == "" {
+		p.Schedule = def.Schedule
+	}
+	return p
+}
@@ -0,0 +1,41 @@
+package config
+
+import "testing"
+
+func TestProfileOf(t *testing.T) {
+	def := Profile{
+		Bandwidth: Bandwidth{Download: 1000},
+		Limits:    Limits{Tunnels: 4, RPS: 10},
+		Schedule:  "mon-fri",
+	}
+	c := &Config{Profiles: map[string]Profile{
+		"*":     def,
+		"fast":  {Bandwidth: Bandwidth{Upload: 5, Download: 5000}},
+		"night": {Schedule: "22:00-06:00"},
+		"full":  {Bandwidth: Bandwidth{Upload: 1}, Limits: Limits{Requests: 2}, Schedule: "sat"},
+	}}
+	tests := []struct {
+		user string
+		want Profile
+	}{
+		{"nobody", def},
+		// Setting the bandwidth of a user keeps the default limits and schedule.
+		{"fast", Profile{Bandwidth: Bandwidth{Upload: 5, Download: 5000}, Limits: def.Limits, Schedule: def.Schedule}},
+		{"night", Profile{Bandwidth: def.Bandwidth, Limits: def.Limits, Schedule: "22:00-06:00"}},
+		{"full", c.Profiles["full"]},
+	}
+	for _, tt := range tests {
+		if got := c.ProfileOf(tt.user); got != tt.want {
+			t.Errorf("ProfileOf(%q) = %+v, want %+v", tt.user, got, tt.want)
+		}
+	}
+
+	// Without a "*" profile a user only has their own settings.
+	c = &Config{Profiles: map[string]Profile{"fast": {Bandwidth: Bandwidth{Download: 1}}}}
+	if got := c.ProfileOf("fast"); got != c.Profiles["fast"] {
+		t.Errorf("ProfileOf without defaults = %+v", got)
+	}
+	if got := c.ProfileOf("nobody"); got != (Profile{}) {
+		t.Errorf("ProfileOf of nobody without defaults = %+v", got)
+	}
+}
@@ -0,0 +1,26 @@
+package config
+
+// Route 反向代理路由规则，按顺序匹配，第一条匹配的规则生效
+type Route struct {
+	// 匹配的 Host，支持通配符，如"*.example.com"，也匹配发往该 Host 的代理请求；
+	// 为空或"*"时只匹配直接发给本服务器的 HTTP/1.1 请求
+	Host string `json:"host"`
+
+	// 匹配的路径前缀，如"/app"
+	Path string `json:"path"`
+
+	// 匹配路径的正则表达式，与 path 同时设置时需同时满足
+	PathRegex string `json:"path_regex"`
+
+	// 匹配的请求方法，为空匹配所有
+	Methods []string `json:"methods"`
+
+	// 目标地址或 upstreams 组名
+	Pass string `json:"pass"`
+
+	// 转发前去掉匹配的路径前缀
+	StripPrefix bool `json:"strip_prefix"`
+
+	// 用该值替换匹配的路径前缀；设置了 path_regex 时作为正则替换模板
+	RewritePrefix string `json:"rewrite_prefix"`
+}
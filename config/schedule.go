@@ -0,0 +1,145 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule 日程，如"mon-fri 09:00-18:00 Asia/Shanghai; sat 10:00-12:00 Asia/Shanghai"；
+// 多个时间段以分号分割，每段由星期(如"mon-fri"、"sat,sun")、时间窗口
+// (如"09:00-18:00"，结束早于开始时跨过午夜)和时区(默认本地时区)组成，
+// 省略星期为每天，省略时间窗口为全天
+type Schedule struct {
+	windows []window
+	text    string
+}
+
+type window struct {
+	days       [7]bool
+	start, end int // minutes since midnight
+	loc        *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseSchedule parses a schedule expression.
+func ParseSchedule(s string) (*Schedule, error) {
+	sch := &Schedule{text: s}
+	for _, part := range strings.Split(s, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		w, err := parseWindow(part)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %v", s, err)
+		}
+		sch.windows = append(sch.windows, w)
+	}
+	if len(sch.windows) == 0 {
+		return nil, fmt.Errorf("schedule %q is empty", s)
+	}
+	return sch, nil
+}
+
+func parseWindow(s string) (window, error) {
+	w := window{end: 24 * 60, loc: time.Local}
+	days := false
+	for _, field := range strings.Fields(s) {
+		f := strings.ToLower(field)
+		switch {
+		case strings.Contains(f, ":"):
+			i := strings.Index(f, "-")
+			if i < 0 {
+				return w, fmt.Errorf("bad time window %q", field)
+			}
+			var err error
+			if w.start, err = parseClock(f[:i]); err != nil {
+				return w, err
+			}
+			if w.end, err = parseClock(f[i+1:]); err != nil {
+				return w, err
+			}
+		case f == "utc":
+			w.loc = time.UTC
+		case f == "local":
+			w.loc = time.Local
+		case strings.Contains(field, "/"):
+			loc, err := time.LoadLocation(field)
+			if err != nil {
+				return w, err
+			}
+			w.loc = loc
+		default:
+			for _, r := range strings.Split(f, ",") {
+				from, to := r, r
+				if i := strings.Index(r, "-"); i >= 0 {
+					from, to = r[:i], r[i+1:]
+				}
+				a, ok1 := weekdays[from]
+				b, ok2 := weekdays[to]
+				if !ok1 || !ok2 {
+					return w, fmt.Errorf("bad weekday %q", r)
+				}
+				for d := a; ; d = (d + 1) % 7 {
+					w.days[d] = true
+					if d == b {
+						break
+					}
+				}
+			}
+			days = true
+		}
+	}
+	if !days {
+		for d := range w.days {
+			w.days[d] = true
+		}
+	}
+	return w, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight; "24:00" is the end of the day.
+func parseClock(s string) (int, error) {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	h, err1 := strconv.Atoi(s[:i])
+	m, err2 := strconv.Atoi(s[i+1:])
+	if err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("bad time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// Active reports whether t falls in one of the windows.
+func (s *Schedule) Active(t time.Time) bool {
+	for _, w := range s.windows {
+		lt := t.In(w.loc)
+		min := lt.Hour()*60 + lt.Minute()
+		if w.start <= w.end {
+			if w.days[lt.Weekday()] && min >= w.start && min < w.end {
+				return true
+			}
+			continue
+		}
+		// Past midnight the window belongs to the day it started on.
+		if w.days[lt.Weekday()] && min >= w.start {
+			return true
+		}
+		if w.days[(lt.Weekday()+6)%7] && min < w.end {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the expression the schedule was parsed from.
+func (s *Schedule) String() string {
+	return s.text
+}
@@ -0,0 +1,78 @@
+package config
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestScheduleActive(t *testing.T) {
+	// 2024-01-05 is a Friday.
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2024, 1, day, hour, min, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		schedule string
+		t        time.Time
+		active   bool
+	}{
+		// A window within one day, the end is not in it
+		{"09:00-18:00 UTC", at(5, 9, 0), true},
+		{"09:00-18:00 UTC", at(5, 17, 59), true},
+		{"09:00-18:00 UTC", at(5, 18, 0), false},
+		{"09:00-18:00 UTC", at(5, 8, 59), false},
+		{"00:00-24:00 UTC", at(5, 23, 59), true},
+		// Weekdays only, the whole day
+		{"sat,sun UTC", at(6, 12, 0), true},
+		{"sat,sun UTC", at(5, 23, 59), false},
+		{"mon-fri UTC", at(5, 12, 0), true},
+		{"mon-fri UTC", at(7, 12, 0), false},
+		// Ranges may wrap around the week
+		{"fri-mon UTC", at(7, 12, 0), true},
+		{"fri-mon UTC", at(8, 12, 0), true},
+		{"fri-mon UTC", at(9, 12, 0), false},
+		// Past midnight the window belongs to the day it started on
+		{"22:00-06:00 UTC", at(5, 23, 0), true},
+		{"22:00-06:00 UTC", at(6, 5, 59), true},
+		{"22:00-06:00 UTC", at(6, 6, 0), false},
+		{"22:00-06:00 UTC", at(5, 21, 59), false},
+		{"fri 22:00-06:00 UTC", at(6, 3, 0), true},
+		{"fri 22:00-06:00 UTC", at(5, 3, 0), false},
+		{"sat 22:00-06:00 UTC", at(6, 3, 0), false},
+		// Time zones, Shanghai is UTC+8
+		{"09:00-18:00 Asia/Shanghai", at(5, 1, 0), true},
+		{"09:00-18:00 Asia/Shanghai", at(5, 10, 0), false},
+		{"sat 00:00-02:00 Asia/Shanghai", at(5, 17, 0), true},
+		// Several windows
+		{"mon-fri 09:00-18:00 UTC; sat 10:00-12:00 UTC", at(6, 11, 0), true},
+		{"mon-fri 09:00-18:00 UTC; sat 10:00-12:00 UTC", at(6, 13, 0), false},
+		{"mon-fri 09:00-18:00 UTC; sat 10:00-12:00 UTC", at(5, 13, 0), true},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.schedule)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q): %v", tt.schedule, err)
+			continue
+		}
+		if got := s.Active(tt.t); got != tt.active {
+			t.Errorf("%q at %s: active = %v, want %v", tt.schedule, tt.t.Format("Mon 15:04 MST"), got, tt.active)
+		}
+	}
+}
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		" ; ",
+		"09:00",
+		"09:00-25:00",
+		"09:60-10:00",
+		"someday",
+		"mon-someday",
+		"09:00-18:00 Nowhere/City",
+	} {
+		if _, err := ParseSchedule(s); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded", s)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package config
+
+import "time"
+
+// Token 访问令牌，由web管理界面签发，保存在 token_file 中
+type Token struct {
+	// 令牌编号，用于列表和撤销
+	ID string `json:"id"`
+
+	// 令牌的 SHA-256，令牌本身只在签发时显示一次
+	Hash string `json:"hash"`
+
+	// 令牌所属用户
+	User string `json:"user"`
+
+	// 备注
+	Note string `json:"note"`
+
+	// 签发和过期时间，过期时间为零则不过期
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+
+	// 允许访问的目标域名(含子域名)，为空则不限制
+	Domains []string `json:"domains"`
+
+	// 流量配额，单位字节，0为不限制；Used 为已用流量
+	Quota int64 `json:"quota"`
+	Used  int64 `json:"used"`
+}
+
+// Expired reports whether t can no longer be used.
+func (t *Token) Expired() bool {
+	return !t.Expires.IsZero() && time.Now().After(t.Expires)
+}
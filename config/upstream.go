@@ -0,0 +1,46 @@
+package config
+
+// Upstream 反向代理上游服务器组
+type Upstream struct {
+	// 负载均衡策略: "round_robin"(默认), "least_conn", "weighted", "hash"
+	Strategy string `json:"strategy"`
+
+	// hash 策略的粘滞依据: "ip"(默认) 或 "cookie:<cookie名>"
+	HashKey string `json:"hash_key"`
+
+	// 组内服务器
+	Servers []UpstreamServer `json:"servers"`
+
+	// 主动健康检查
+	HealthCheck HealthCheck `json:"health_check"`
+}
+
+// UpstreamServer 上游服务器
+type UpstreamServer struct {
+	// 服务器地址,eg:"127.0.0.1:8090"
+	Addr string `json:"addr"`
+
+	// 权重，用于 weighted 和 hash 策略，默认为1
+	Weight int `json:"weight"`
+}
+
+// HealthCheck 健康检查配置
+type HealthCheck struct {
+	// 检查方式: "http" 或 "tcp"，为空则不检查
+	Type string `json:"type"`
+
+	// http 检查的请求路径，默认为"/"
+	Path string `json:"path"`
+
+	// 检查间隔，单位秒，默认10秒
+	Interval int64 `json:"interval"`
+
+	// 检查超时，单位秒，默认2秒
+	Timeout int64 `json:"timeout"`
+
+	// 连续失败多少次后剔除，默认3次
+	Fall int `json:"fall"`
+
+	// 连续成功多少次后恢复，默认2次
+	Rise int `json:"rise"`
+}
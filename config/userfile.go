@@ -0,0 +1,89 @@
+package config
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UserFile 外部用户文件，与 users 一起用于代理认证
+type UserFile struct {
+	// 文件路径，为空则不使用
+	Path string `json:"path"`
+
+	// 格式: "htpasswd"、"json"({"用户名":"密码"})、"csv"(用户名,密码)，
+	// 为空时按扩展名判断，其他扩展名视为 htpasswd
+	Format string `json:"format"`
+
+	// 检查文件变化的间隔，单位秒，默认5秒
+	Interval int `json:"interval"`
+}
+
+// ReadUserFile reads the users of f. Passwords may be hashes or plaintext.
+func ReadUserFile(f UserFile) (map[string]string, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	format := f.Format
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(f.Path)) {
+		case ".json":
+			format = "json"
+		case ".csv":
+			format = "csv"
+		default:
+			format = "htpasswd"
+		}
+	}
+
+	users := make(map[string]string)
+	switch format {
+	case "json":
+		if err := json.NewDecoder(file).Decode(&users); err != nil {
+			return nil, err
+		}
+	case "csv":
+		r := csv.NewReader(file)
+		r.FieldsPerRecord = -1
+		r.Comment = '#'
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			if len(rec) < 2 {
+				continue
+			}
+			users[strings.TrimSpace(rec[0])] = strings.TrimSpace(rec[1])
+		}
+	case "htpasswd":
+		b, err := io.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			i := strings.Index(line, ":")
+			if i < 0 {
+				continue
+			}
+			users[line[:i]] = line[i+1:]
+		}
+	default:
+		return nil, fmt.Errorf("unknown user file format %q", format)
+	}
+	return users, nil
+}
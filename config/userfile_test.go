@@ -0,0 +1,50 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadUserFile(t *testing.T) {
+	tests := []struct {
+		name   string // the file name, its extension picks the format
+		format string
+		body   string
+		want   map[string]string // nil when reading fails
+	}{
+		{"users.htpasswd", "", "# comment\nalice:$2y$05$abc\n\n  bob:plain:with:colons \nbroken\n",
+			map[string]string{"alice": "$2y$05$abc", "bob": "plain:with:colons"}},
+		{"users", "", "alice:secret\n", map[string]string{"alice": "secret"}},
+		{"users.json", "", `{"alice":"secret","bob":"$2a$10$x"}`, map[string]string{"alice": "secret", "bob": "$2a$10$x"}},
+		{"users.csv", "", "# user,password\nalice, secret\nbob,\"p,w\",extra\nlonely\n",
+			map[string]string{"alice": "secret", "bob": "p,w"}},
+		{"users.txt", "json", `{"alice":"secret"}`, map[string]string{"alice": "secret"}},
+		{"users.json", "", `{"alice":`, nil},
+		{"users.csv", "", "alice,\"secret\n", nil},
+		{"users", "xml", "<users/>", nil},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(path, []byte(tt.body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadUserFile(UserFile{Path: path, Format: tt.format})
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s (%s): got %q, want an error", tt.name, tt.format, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s (%s): %v", tt.name, tt.format, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s (%s): got %q, want %q", tt.name, tt.format, got, tt.want)
+		}
+	}
+	if _, err := ReadUserFile(UserFile{Path: filepath.Join(dir, "missing")}); err == nil {
+		t.Error("missing file read")
+	}
+}
@@ -0,0 +1,15 @@
+package lib
+
+// Credentials are what a client presented to the proxy.
+type Credentials struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	ClientIP string `json:"client_ip"`
+	Target   string `json:"target"`
+}
+
+// Authenticator decides whether credentials may use the proxy.
+// An error means no decision could be made.
+type Authenticator interface {
+	Authenticate(c Credentials) (bool, error)
+}
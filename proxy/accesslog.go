@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"httpproxy/config"
+)
+
+// AccessRecord describes one request or tunnel.
+type AccessRecord struct {
+	ID        string        `json:"id"`
+	Time      time.Time     `json:"time"`
+	Client    string        `json:"client"`
+	User      string        `json:"user"`
+	Method    string        `json:"method"`
+	Target    string        `json:"target"`
+	Proto     string        `json:"proto"`
+	Status    int           `json:"status"`
+	BytesIn   int64         `json:"bytes_in"`
+	BytesOut  int64         `json:"bytes_out"`
+	Duration  time.Duration `json:"duration"`
+	Cache     string        `json:"cache,omitempty"`
+	Auth      string        `json:"auth"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+
+	// bytesIn and bytesOut are counted while the request runs, the
+	// transport reads request bodies from its own goroutine.
+	bytesIn, bytesOut atomic.Int64
+}
+
+func newAccessRecord(req *http.Request) *AccessRecord {
+	target := req.RequestURI
+	if req.Method == "CONNECT" {
+		target = req.Host
+	}
+	return &AccessRecord{
+		ID:        newRequestID(),
+		Time:      time.Now(),
+		Client:    req.RemoteAddr,
+		Method:    req.Method,
+		Target:    target,
+		Proto:     req.Proto,
+		Auth:      "none",
+		Referer:   req.Referer(),
+		UserAgent: req.UserAgent(),
+	}
+}
+
+// tunnelled accounts a spliced connection. HTTP/2 streams are counted
+// by responseRecorder and countingBody instead.
+func (r *AccessRecord) tunnelled(status int, up, down int64) {
+	if r.Status == 0 {
+		r.Status = status
+	}
+	r.bytesIn.Add(up)
+	r.bytesOut.Add(down)
+}
+
+// accessLogger writes records to the access log.
+type accessLogger struct {
+	mu   sync.Mutex
+	w    io.Writer
+	tmpl *template.Template
+	kind string
+}
+
+// openAccessLog opens the access log c, or returns nil when there is none.
+func openAccessLog(c config.AccessLog) *accessLogger {
+	if c.Path == "" {
+		return nil
+	}
+
+	l := &accessLogger{kind: c.Format}
+	switch c.Format {
+	case "", "json":
+		l.kind = "json"
+	case "combined":
+	default:
+		tmpl, err := template.New("access").Parse(c.Format)
+		if err != nil {
+			log.Errorf("access log: bad format: %v", err)
+			return nil
+		}
+		l.kind, l.tmpl = "template", tmpl
+	}
+
+	if c.Path == "-" {
+		l.w = os.Stdout
+	} else {
+		f, err := os.OpenFile(c.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			log.Errorf("access log: %v", err)
+			return nil
+		}
+		l.w = f
+	}
+	return l
+}
+
+// close closes the file of a replaced access log.
+func (l *accessLogger) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.w.(io.Closer); ok && l.w != os.Stdout {
+		c.Close()
+	}
+}
+
+func (l *accessLogger) write(r *AccessRecord) {
+	var line []byte
+	switch l.kind {
+	case "json":
+		line, _ = json.Marshal(r)
+	case "combined":
+		line = []byte(combined(r))
+	default:
+		var b strings.Builder
+		if err := l.tmpl.Execute(&b, r); err != nil {
+			log.Errorf("access log: %v", err)
+			return
+		}
+		line = []byte(b.String())
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line)
+}
+
+// combined formats r in the Apache combined log format.
+func combined(r *AccessRecord) string {
+	host, _, err := net.SplitHostPort(r.Client)
+	if err != nil {
+		host = r.Client
+	}
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return s
+	}
+	size := "-"
+	if r.BytesOut > 0 {
+		size = fmt.Sprint(r.BytesOut)
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"",
+		host, dash(r.User), r.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method, r.Target, r.Proto, r.Status, size,
+		dash(r.Referer), dash(r.UserAgent))
+}
+
+// logAccess finishes the request's record and writes it.
+func (proxy *Handler) logAccess() {
+	r := proxy.rec
+	r.User = proxy.User
+	r.Duration = time.Since(r.Time)
+	r.BytesIn, r.BytesOut = r.bytesIn.Load(), r.bytesOut.Load()
+	observe(r)
+	if proxy.token != nil {
+		n := r.BytesIn + r.BytesOut
+		// Tunnels charge as they go.
+		if proxy.charged != nil {
+			n -= proxy.charged.Load()
+		}
+		addTokenUsage(proxy.token, n)
+	}
+	if l := proxy.s.accessLog; l != nil {
+		l.write(r)
+	}
+}
+
+// responseRecorder counts the status and bytes sent to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	rec *AccessRecord
+}
+
+func (w *responseRecorder) WriteHeader(code int) {
+	if w.rec.Status == 0 && code >= 200 {
+		w.rec.Status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.rec.Status == 0 {
+		w.rec.Status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.rec.bytesOut.Add(int64(n))
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the original writer.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// hijackRecorder is a responseRecorder whose connection can be hijacked.
+type hijackRecorder struct {
+	*responseRecorder
+}
+
+func (w hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// record wraps rw so the request's record sees what is written,
+// keeping the ability to hijack when rw has it.
+func (proxy *Handler) record(rw http.ResponseWriter) http.ResponseWriter {
+	w := &responseRecorder{rw, proxy.rec}
+	if _, ok := rw.(http.Hijacker); ok {
+		return hijackRecorder{w}
+	}
+	return w
+}
+
+// countingBody counts the bytes of a request body.
+type countingBody struct {
+	io.ReadCloser
+	rec *AccessRecord
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.rec.bytesIn.Add(int64(n))
+	return n, err
+}
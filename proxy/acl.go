@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpproxy/config"
+)
+
+type portRange struct {
+	lo, hi int
+}
+
+// aclRule is a config.ACLRule ready for matching.
+type aclRule struct {
+	name    string
+	allow   bool
+	users   map[string]bool // nil matches everyone
+	domains []string
+	nets    []*net.IPNet
+	ports   []portRange
+	methods map[string]bool
+	// schedule limits when the rule applies, nil for always
+	schedule *config.Schedule
+}
+
+// compileACL compiles c.ACL. Bad entries are logged and skipped.
+func compileACL(c *config.Config) []*aclRule {
+	rules := make([]*aclRule, 0, len(c.ACL.Rules))
+	for i, r := range c.ACL.Rules {
+		rule := &aclRule{name: r.Name, allow: r.Action == "allow", domains: r.Domains}
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("#%d", i)
+		}
+		if len(r.Users) > 0 {
+			rule.users = make(map[string]bool)
+			for _, u := range r.Users {
+				if strings.HasPrefix(u, "@") {
+					for _, member := range c.ACL.Groups[u[1:]] {
+						rule.users[member] = true
+					}
+				} else {
+					rule.users[u] = true
+				}
+			}
+		}
+		for _, cidr := range r.CIDRs {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				log.Errorf("acl rule %s: %v", rule.name, err)
+				continue
+			}
+			rule.nets = append(rule.nets, n)
+		}
+		for _, p := range r.Ports {
+			lo, hi, err := config.ParsePortRange(p)
+			if err != nil {
+				log.Errorf("acl rule %s: %v", rule.name, err)
+				continue
+			}
+			rule.ports = append(rule.ports, portRange{lo, hi})
+		}
+		if r.Schedule != "" {
+			s, err := config.ParseSchedule(r.Schedule)
+			if err != nil {
+				log.Errorf("acl rule %s: %v", rule.name, err)
+				continue
+			}
+			rule.schedule = s
+		}
+		if len(r.Methods) > 0 {
+			rule.methods = make(map[string]bool)
+			for _, m := range r.Methods {
+				rule.methods[strings.ToUpper(m)] = true
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// destination is what a request is trying to reach.
+type destination struct {
+	host   string
+	port   int
+	method string
+
+	ips      []net.IP
+	err      error
+	resolved bool
+}
+
+func destinationOf(req *http.Request) *destination {
+	// "example.com." is the same host as "example.com".
+	host := strings.TrimSuffix(strings.ToLower(req.URL.Hostname()), ".")
+	d := &destination{host: host, method: req.Method}
+	d.port, _ = strconv.Atoi(req.URL.Port())
+	if d.port == 0 {
+		d.port = 80
+		if req.URL.Scheme == "https" || req.URL.Scheme == "wss" {
+			d.port = 443
+		}
+	}
+	if ip := net.ParseIP(d.host); ip != nil {
+		d.ips, d.resolved = []net.IP{ip}, true
+	}
+	return d
+}
+
+// addrs resolves the destination, once, for CIDR rules.
+func (d *destination) addrs() ([]net.IP, error) {
+	if !d.resolved {
+		d.ips, d.err = net.LookupIP(d.host)
+		d.resolved = true
+	}
+	return d.ips, d.err
+}
+
+func (r *aclRule) match(user string, d *destination) bool {
+	if r.schedule != nil && !r.schedule.Active(time.Now()) {
+		return false
+	}
+	if r.users != nil && !r.users[user] {
+		return false
+	}
+	if r.methods != nil && !r.methods[d.method] {
+		return false
+	}
+	if len(r.ports) > 0 {
+		ok := false
+		for _, p := range r.ports {
+			if d.port >= p.lo && d.port <= p.hi {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(r.domains) == 0 && len(r.nets) == 0 {
+		return true
+	}
+	for _, domain := range r.domains {
+		domain = strings.ToLower(domain)
+		if domain == "*" || d.host == domain || strings.HasSuffix(d.host, "."+domain) {
+			return true
+		}
+	}
+	if len(r.nets) > 0 {
+		ips, err := d.addrs()
+		if err != nil {
+			// A parent proxy may still resolve the host, so a deny
+			// rule must not be passed by a name we cannot resolve.
+			return !r.allow
+		}
+		for _, ip := range ips {
+			for _, n := range r.nets {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// aclDecision tells whether user may reach d, and the rule deciding it.
+func (s *state) aclDecision(user string, d *destination) (bool, string) {
+	for _, r := range s.aclRules {
+		if r.match(user, d) {
+			return r.allow, r.name
+		}
+	}
+	return s.cnfg.ACL.Default != "deny", "default"
+}
+
+// ACL enforces the ACL for the current user. It answers 403 and
+// returns true when the request is denied.
+func (proxy *Handler) ACL(rw http.ResponseWriter, req *http.Request) bool {
+	if len(proxy.s.aclRules) == 0 && proxy.s.cnfg.ACL.Default != "deny" {
+		return false
+	}
+	allow, name := proxy.s.aclDecision(proxy.User, destinationOf(req))
+	if allow {
+		return false
+	}
+	log.Infof("%s: %s %s denied by acl rule %s", proxy.User, req.Method, req.URL.Host, name)
+	proxy.errorPage(rw, req, http.StatusForbidden, "blocked", "acl: "+name)
+	return true
+}
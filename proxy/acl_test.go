@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"httpproxy/config"
+)
+
+func TestACLDecision(t *testing.T) {
+	s := &state{cnfg: config.Config{ACL: config.ACL{
+		Groups:  map[string][]string{"staff": {"alice", "bob"}},
+		Default: "deny",
+		Rules: []config.ACLRule{
+			{Name: "no-smtp", Action: "deny", Ports: []string{"25"}},
+			{Name: "read-only", Action: "allow", Users: []string{"carol"}, Methods: []string{"get", "HEAD"}},
+			{Action: "allow", Domains: []string{"Example.com"}},
+			{Name: "intranet", Action: "allow", Users: []string{"@staff"}, CIDRs: []string{"10.0.0.0/8"}},
+			{Name: "no-intranet", Action: "deny", CIDRs: []string{"10.0.0.0/8"}},
+			{Name: "never", Action: "allow", Domains: []string{"*"}, Schedule: "00:00-00:00 UTC"},
+		},
+	}}}
+	s.aclRules = compileACL(&s.cnfg)
+	tests := []struct {
+		user, method, target string
+		allow                bool
+		rule                 string
+	}{
+		{"alice", "CONNECT", "mail.example.com:25", false, "no-smtp"},
+		{"alice", "GET", "http://10.1.2.3/", true, "intranet"},
+		{"bob", "CONNECT", "10.1.2.3:443", true, "intranet"},
+		{"carol", "POST", "http://10.1.2.3/", false, "no-intranet"},
+		{"carol", "GET", "http://other.org/", true, "read-only"},
+		{"carol", "POST", "http://192.0.2.1/", false, "default"},
+		{"dave", "POST", "http://www.example.com/", true, "#2"},
+		{"dave", "CONNECT", "example.com.:443", true, "#2"},
+		{"dave", "GET", "http://192.0.2.1/", false, "default"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.method == "CONNECT" {
+			req.URL.Host = tt.target
+		}
+		allow, rule := s.aclDecision(tt.user, destinationOf(req))
+		if allow != tt.allow || rule != tt.rule {
+			t.Errorf("%s %s %s: got %v by %s, want %v by %s", tt.user, tt.method, tt.target, allow, rule, tt.allow, tt.rule)
+		}
+	}
+}
+
+func TestACLUnresolved(t *testing.T) {
+	// A host that does not resolve matches deny rules with CIDRs and
+	// not allow rules, so it cannot slip past either.
+	tests := []struct {
+		action string
+		def    string
+		allow  bool
+		rule   string
+	}{
+		{"deny", "allow", false, "cidr"},
+		{"allow", "deny", false, "default"},
+	}
+	for _, tt := range tests {
+		s := &state{cnfg: config.Config{ACL: config.ACL{Default: tt.def, Rules: []config.ACLRule{
+			{Name: "cidr", Action: tt.action, CIDRs: []string{"10.0.0.0/8"}},
+		}}}}
+		s.aclRules = compileACL(&s.cnfg)
+		req := httptest.NewRequest("GET", "http://nothing.invalid/", nil)
+		allow, rule := s.aclDecision("alice", destinationOf(req))
+		if allow != tt.allow || rule != tt.rule {
+			t.Errorf("%s rule: got %v by %s, want %v by %s", tt.action, allow, rule, tt.allow, tt.rule)
+		}
+	}
+}
+
+func TestDestinationOf(t *testing.T) {
+	tests := []struct {
+		method, target string
+		host           string
+		port           int
+	}{
+		{"GET", "http://Example.COM./x", "example.com", 80},
+		{"GET", "https://example.com/", "example.com", 443},
+		{"GET", "ws://example.com:8080/", "example.com", 8080},
+		{"GET", "wss://example.com/", "example.com", 443},
+		{"CONNECT", "[2001:db8::1]:443", "2001:db8::1", 443},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.method == "CONNECT" {
+			req.URL.Host = tt.target
+		}
+		d := destinationOf(req)
+		if d.host != tt.host || d.port != tt.port {
+			t.Errorf("%s %s: got %s port %d, want %s port %d", tt.method, tt.target, d.host, d.port, tt.host, tt.port)
+		}
+	}
+}
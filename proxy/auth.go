@@ -6,54 +6,137 @@ import (
 	"net"
 	"net/http"
 	"strings"
-)
 
-var HTTP_407 = []byte("HTTP/1.1 407 Proxy Authorization Required\r\nProxy-Authenticate: Basic realm=\"Secure Proxys\"\r\n\r\n")
+	"httpproxy/lib"
+)
 
 //Auth provides basic authorizaton for proxy server.
 func (proxy *Handler) Auth(rw http.ResponseWriter, req *http.Request) bool {
 	var err error
-	if cnfg.Reverse == false && cnfg.Auth == true { //代理服务器登入认证
+	c := &proxy.s.cnfg
+	if user := certUser(c, req); user != "" && c.Reverse == false {
+		// A verified client certificate stands in for Proxy-Authorization.
+		proxy.User = user
+		proxy.rec.Auth = "certificate"
+	} else if c.Reverse == false && c.Auth == true { //代理服务器登入认证
 		if proxy.User, err = proxy.auth(rw, req); err != nil {
 			log.Debug(err)
+			proxy.rec.Auth = "failure"
+			if c.Failover == "" && proxy.rec.Status == 0 && !camouflaged(c, req) {
+				// The challenge went out on the hijacked connection.
+				proxy.rec.Status = http.StatusProxyAuthRequired
+			}
 			return true
 		}
+		proxy.rec.Auth = "success"
+		if proxy.token != nil {
+			proxy.rec.Auth = "token"
+		}
 	} else {
 		proxy.User = "Anonymous"
+		proxy.rec.Auth = "none"
 	}
 
 	return false
 }
 
-//Auth provides basic authorizaton for proxy server.
+//auth provides basic and digest authorizaton for proxy server.
 func (proxy *Handler) auth(rw http.ResponseWriter, req *http.Request) (string, error) {
 	auth := req.Header.Get("Proxy-Authorization")
+	ip := clientIP(req)
+	if strings.HasPrefix(auth, "Bearer ") {
+		keys := lockoutKeys(ip, "")
+		if proxy.s.lockedOut(keys...) {
+			proxy.AuthFailover(rw, req)
+			return "", errors.New("Locked out")
+		}
+		t := proxy.s.lookupToken(strings.TrimSpace(auth[len("Bearer "):]))
+		if t == nil {
+			proxy.s.loginFailed(keys...)
+			proxy.AuthFailover(rw, req)
+			return "", errors.New("Unknown or expired token")
+		}
+		proxy.token = t
+		return t.User, nil
+	}
+	if strings.HasPrefix(auth, "Digest ") && proxy.s.cnfg.DigestEnabled() {
+		keys := lockoutKeys(ip, parseDigest(auth[len("Digest "):])["username"])
+		if proxy.s.lockedOut(keys...) {
+			// Banned clients get the same answer as a wrong password.
+			proxy.AuthFailover(rw, req)
+			return "", errors.New("Locked out")
+		}
+		user, err := proxy.s.checkDigest(req, auth[len("Digest "):])
+		if err == errStale {
+			// The client knows the password, let it retry with a new nonce.
+			NeedAuth(rw, proxy.s.proxyChallenge(req, true))
+			return "", err
+		}
+		if err != nil {
+			proxy.s.loginFailed(keys...)
+			proxy.AuthFailover(rw, req)
+			return "", err
+		}
+		loginSucceeded(keys...)
+		return user, nil
+	}
+	if !proxy.s.cnfg.BasicEnabled() {
+		auth = ""
+	}
 	auth = strings.Replace(auth, "Basic ", "", 1)
 
 	if auth == "" {
-		AuthFailover(rw, req)
+		proxy.AuthFailover(rw, req)
 		return "", errors.New("Need Proxy Authorization!")
 	}
 	data, err := base64.StdEncoding.DecodeString(auth)
 	if err != nil {
 		log.Debug("when decoding %v, got an error of %v", auth, err)
+		proxy.AuthFailover(rw, req)
 		return "", errors.New("Fail to decoding Proxy-Authorization")
 	}
 
 	userPasswdPair := strings.Split(string(data), ":")
 	if len(userPasswdPair) != 2 {
-		AuthFailover(rw, req)
+		proxy.AuthFailover(rw, req)
 		return "", errors.New("Fail to log in")
 	}
-	if Check(userPasswdPair[0], userPasswdPair[1]) == false {
-		AuthFailover(rw, req)
+	keys := lockoutKeys(ip, userPasswdPair[0])
+	if proxy.s.lockedOut(keys...) {
+		proxy.AuthFailover(rw, req)
+		return "", errors.New("Locked out")
+	}
+	// A token may be given as the password of its user.
+	if t := proxy.s.lookupToken(userPasswdPair[1]); t != nil && t.User == userPasswdPair[0] {
+		loginSucceeded(keys...)
+		proxy.token = t
+		return t.User, nil
+	}
+	ok, err := proxy.s.authenticator.Authenticate(lib.Credentials{
+		User:     userPasswdPair[0],
+		Password: userPasswdPair[1],
+		ClientIP: ip,
+		Target:   req.Host,
+	})
+	if err != nil {
+		log.Errorf("failed to authenticate %s: %v", userPasswdPair[0], err)
+	} else if !ok {
+		proxy.s.loginFailed(keys...)
+	}
+	if !ok {
+		proxy.AuthFailover(rw, req)
 		return "", errors.New("Fail to log in")
 	}
+	loginSucceeded(keys...)
 	return userPasswdPair[0], nil
 }
 
 func NeedAuth(rw http.ResponseWriter, challenge []byte) error {
-	hj, _ := rw.(http.Hijacker)
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		// HTTP/2 streams cannot be hijacked.
+		return writeRawResponse(rw, challenge)
+	}
 	Client, _, err := hj.Hijack()
 	if err != nil {
 		return errors.New("Fail to get Tcp connection of Client")
@@ -66,36 +149,45 @@ func NeedAuth(rw http.ResponseWriter, challenge []byte) error {
 
 
 //反向代理到外部服务器，模仿其行为
-func AuthFailover(rw http.ResponseWriter, req *http.Request) {
+func (proxy *Handler) AuthFailover(rw http.ResponseWriter, req *http.Request) {
+	c := &proxy.s.cnfg
+	if camouflaged(c, req) {
+		Camouflage(c, rw, req)
+		return
+	}
 	// Send 407 if no failover is set
-	if cnfg.Failover == "" {
-		NeedAuth(rw, HTTP_407)
+	if c.Failover == "" {
+		NeedAuth(rw, proxy.s.proxyChallenge(req, false))
+		return
+	}
+	if req.ProtoMajor == 2 {
+		http2Failover(c.Failover, rw, req)
 		return
 	}
 
-	remote, err := net.Dial("tcp", cnfg.Failover) //建立failover和代理服务器的tcp连接
+	remote, err := net.Dial("tcp", c.Failover) //建立failover和代理服务器的tcp连接
 	if err != nil {
 		log.Errorf("%s failed set up a connection to failover server.", "Unauthorized")
-		http.Error(rw, "Failed", http.StatusBadRequest)
+		writeErrorPage(rw, req, http.StatusBadGateway, "upstream", "", "failover")
 		return
 	}
 	hj, _ := rw.(http.Hijacker)
-	client, _, err := hj.Hijack() //获取客户端与代理服务器的tcp连接
+	conn, brw, err := hj.Hijack() //获取客户端与代理服务器的tcp连接
 	if err != nil {
 		log.Errorf("%s failed to get Tcp connection of %s", "Unauthorized", req.RequestURI)
-		http.Error(rw, "Failed", http.StatusBadRequest)
+		writeErrorPage(rw, req, http.StatusInternalServerError, "upstream", "", "")
 		return
 	}
 	// 将请求发送到 failover 服务器
 	req.Write(remote)
-	go copyRemoteToClient("Unauthorized", remote, client)
-	go copyRemoteToClient("Unauthorized", client, remote)
+	go splice("Unauthorized", c.Failover, &bufferedConn{conn, brw.Reader}, remote)
 }
 
-// Check checks username and password
-func Check(user, passwd string) bool {
-	if user != "" && passwd != "" && cnfg.User[user] == passwd {
-		return true
+// checkUser checks username and password against the stored hash
+func (s *state) checkUser(user, passwd string) bool {
+	hash, ok := s.lookupUser(user)
+	if user == "" || passwd == "" || !ok {
+		return false
 	}
-	return false
+	return verifyPassword(hash, passwd)
 }
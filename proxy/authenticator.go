@@ -0,0 +1,44 @@
+package proxy
+
+import (
+	"time"
+
+	"httpproxy/auth"
+	"httpproxy/lib"
+)
+
+// RegisterAuthenticator replaces the authenticator until the next
+// reload or config change.
+func RegisterAuthenticator(a lib.Authenticator) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	s := *current.Load()
+	s.authenticator = a
+	current.Store(&s)
+}
+
+// localAuthenticator checks users and the user file.
+type localAuthenticator struct {
+	s *state
+}
+
+func (a localAuthenticator) Authenticate(c lib.Credentials) (bool, error) {
+	return a.s.checkUser(c.User, c.Password), nil
+}
+
+// newAuthenticator returns the authenticator the config of s asks for.
+func newAuthenticator(s *state) lib.Authenticator {
+	a := s.cnfg.AuthRequest
+	if a.URL == "" {
+		return localAuthenticator{s}
+	}
+	ttl := time.Duration(a.TTL) * time.Second
+	if a.TTL == 0 {
+		ttl = time.Minute
+	}
+	timeout := time.Duration(a.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return auth.NewHTTPAuthenticator(a.URL, ttl, timeout)
+}
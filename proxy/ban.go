@@ -1,26 +1,308 @@
 package proxy
 
 import (
+	"net"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"httpproxy/config"
+)
+
+// banRule is a gfwlist entry ready for matching. The syntax is the
+// Adblock style of gfwlist, as the client parses it, plus CIDRs and ports:
+//
+//	example.com, ||example.com  the domain and its subdomains
+//	=example.com                 only this host
+//	*.example.com                a wildcard host
+//	|https://example.com/path    a URL prefix, may contain *
+//	/regexp/                     a regexp on the URL
+//	10.0.0.0/8, 192.0.2.1        destination addresses
+//	:25, :6660-6669              destination ports
+//	keyword                      a keyword in the URL
+//
+// Rules starting with @@ allow what they match, overriding any deny rule.
+// A rule ending in $schedule=<schedule> only applies at those times.
+type banRule struct {
+	rule     string
+	source   string // "gfwlist" or the blocklist it came from
+	allow    bool
+	schedule *config.Schedule
+
+	domain string // a plain domain, matched without a regexp
+	exact  bool   // domain does not cover subdomains
+
+	// index is the only host the rule can match, with its subdomains
+	// when sub is set; "" when it may match any host.
+	index string
+	sub   bool
+
+	host   *regexp.Regexp // matched against the host
+	url    *regexp.Regexp // matched against the URL
+	net    *net.IPNet
+	lo, hi int
+}
+
+// banList is the compiled ban list. Rules tied to a host, the bulk of
+// subscribed lists, are looked up by host instead of one by one.
+type banList struct {
+	rules   []*banRule
+	domains map[string][]*banRule // the domain and its subdomains
+	hosts   map[string][]*banRule // only the host
+}
+
+// bans is rebuilt by reloads, the web admin and blocklist refreshes,
+// and read by requests without a lock.
+var (
+	bans   atomic.Pointer[banList]
+	bansMu sync.Mutex // serializes rebuilds
 )
 
+// maxScannedBanRules is how many rules tried one by one are expected
+// to be fine, more are logged at reload.
+const maxScannedBanRules = 1000
+
+func init() {
+	bans.Store(&banList{})
+}
+
+func (b *banList) add(r *banRule) {
+	switch {
+	case r.index == "":
+		b.rules = append(b.rules, r)
+	case r.sub:
+		b.domains[r.index] = append(b.domains[r.index], r)
+	default:
+		b.hosts[r.index] = append(b.hosts[r.index], r)
+	}
+}
+
+func (b *banList) empty() bool {
+	return len(b.rules) == 0 && len(b.domains) == 0 && len(b.hosts) == 0
+}
+
+// domainLike tells domain rules from keywords.
+var domainLike = regexp.MustCompile(`^[a-z0-9-]+(\.[a-z0-9-]+)+$`)
+
+// glob turns a pattern with * wildcards into a regexp source.
+func glob(pattern string) string {
+	return strings.Replace(regexp.QuoteMeta(pattern), `\*`, `.*`, -1)
+}
+
+// prefixHost returns the host a URL prefix pattern is limited to, or ""
+// when it may match other hosts too, like http://example.com matches
+// http://example.com.evil.org/.
+func prefixHost(prefix string) string {
+	i := strings.Index(prefix, "://")
+	if i <= 0 || strings.Contains(prefix[:i], "*") {
+		return ""
+	}
+	rest := prefix[i+3:]
+	j := strings.IndexAny(rest, "/:")
+	if j < 0 {
+		return ""
+	}
+	host := strings.ToLower(rest[:j])
+	if !domainLike.MatchString(host) {
+		return ""
+	}
+	return host
+}
+
+func compileBanRule(s string) (*banRule, error) {
+	r := &banRule{rule: s}
+	if strings.HasPrefix(s, "@@") {
+		r.allow = true
+		s = s[2:]
+	}
+	var err error
+	if i := strings.LastIndex(s, "$schedule="); i >= 0 {
+		if r.schedule, err = config.ParseSchedule(s[i+len("$schedule="):]); err != nil {
+			return nil, err
+		}
+		s = s[:i]
+	}
+	switch {
+	case strings.HasPrefix(s, "/") && strings.HasSuffix(s, "/") && len(s) > 1:
+		r.url, err = regexp.Compile(s[1 : len(s)-1])
+	case strings.HasPrefix(s, "||") && !strings.Contains(s, "*"):
+		r.domain = strings.ToLower(s[2:])
+		r.index, r.sub = r.domain, true
+	case strings.HasPrefix(s, "||"):
+		r.host, err = regexp.Compile(`(?i)^(.*\.)?` + glob(s[2:]) + `$`)
+	case strings.HasPrefix(s, "|"):
+		r.url, err = regexp.Compile(`(?i)^` + glob(s[1:]))
+		r.index = prefixHost(s[1:])
+	case strings.HasPrefix(s, "=") && !strings.Contains(s, "*"):
+		r.domain, r.exact = strings.ToLower(s[1:]), true
+		r.index = r.domain
+	case strings.HasPrefix(s, "="):
+		r.host, err = regexp.Compile(`(?i)^` + glob(s[1:]) + `$`)
+	case strings.HasPrefix(s, ":"):
+		r.lo, r.hi, err = config.ParsePortRange(s[1:])
+	case strings.Contains(s, "/") && net.ParseIP(s[:strings.Index(s, "/")]) != nil:
+		_, r.net, err = net.ParseCIDR(s)
+	case net.ParseIP(s) != nil:
+		ip := net.ParseIP(s)
+		bits := 8 * len(ip.To4())
+		if bits == 0 {
+			bits = 8 * net.IPv6len
+		}
+		r.net = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	case strings.Contains(s, "*") && !strings.Contains(s, "/"):
+		r.host, err = regexp.Compile(`(?i)^` + glob(s) + `$`)
+		if strings.HasPrefix(s, "*.") && !strings.Contains(s[2:], "*") {
+			r.index, r.sub = strings.ToLower(s[2:]), true
+		}
+	case domainLike.MatchString(strings.ToLower(s)):
+		r.domain = strings.ToLower(s)
+		r.index, r.sub = r.domain, true
+	default:
+		r.url, err = regexp.Compile(`(?i)` + glob(s))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// initBanList compiles the gfwlist of the current config and the
+// subscribed blocklists.
+// Bad entries are logged and skipped.
+func initBanList() {
+	bansMu.Lock()
+	defer bansMu.Unlock()
+	b := &banList{domains: make(map[string][]*banRule), hosts: make(map[string][]*banRule)}
+	add := func(source string, list []string) {
+		for _, s := range list {
+			s = strings.TrimSpace(s)
+			// Comments and headers of gfwlist files
+			if s == "" || strings.HasPrefix(s, "!") || strings.HasPrefix(s, "[") {
+				continue
+			}
+			r, err := compileBanRule(s)
+			if err != nil {
+				log.Errorf("%s rule %s: %v", source, s, err)
+				continue
+			}
+			r.source = source
+			b.add(r)
+		}
+	}
+	add("gfwlist", current.Load().cnfg.GFWList)
+	blocklistEntries(add)
+	if len(b.rules) > maxScannedBanRules {
+		log.Warningf("%d ban rules are not tied to a host and are tried on every request", len(b.rules))
+	}
+	bans.Store(b)
+}
+
+// urlOf returns the URL a request is for, with the host of d. CONNECT
+// has none, so it is guessed from the port, like the client does.
+func urlOf(req *http.Request, d *destination) string {
+	host := d.host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if req.Method != "CONNECT" {
+		u := *req.URL
+		u.User = nil
+		u.Host = host
+		if port := req.URL.Port(); port != "" {
+			u.Host += ":" + port
+		}
+		return u.String()
+	}
+	scheme := "http"
+	if d.port == 443 {
+		scheme = "https"
+	}
+	if d.port != 80 && d.port != 443 {
+		host += ":" + strconv.Itoa(d.port)
+	}
+	return scheme + "://" + host + "/"
+}
+
+func (r *banRule) match(d *destination, url string) bool {
+	if r.schedule != nil && !r.schedule.Active(time.Now()) {
+		return false
+	}
+	switch {
+	case r.domain != "":
+		return d.host == r.domain || !r.exact && strings.HasSuffix(d.host, "."+r.domain)
+	case r.host != nil:
+		return r.host.MatchString(d.host)
+	case r.url != nil:
+		return r.url.MatchString(url)
+	case r.net != nil:
+		ips, err := d.addrs()
+		if err != nil {
+			// Unresolved hosts are denied, not let through.
+			return !r.allow
+		}
+		for _, ip := range ips {
+			if r.net.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	default:
+		return d.port >= r.lo && d.port <= r.hi
+	}
+}
+
 func (proxy *Handler) Ban(rw http.ResponseWriter, req *http.Request) bool {
-	if len(cnfg.GFWList) > 0 {
+	if !bans.Load().empty() {
 		return proxy.ban(rw, req)
 	}
 
 	return false
 }
 
-func (proxy *Handler) ban(rw http.ResponseWriter, req *http.Request) bool {
-	for _, gfwlist := range cnfg.GFWList { //屏蔽列表，检查访问对象是否被屏蔽
-		if strings.Index(req.RequestURI, gfwlist) != -1 && gfwlist != "" {
-			log.Info("%s try to visit forbidden website %s", proxy.User, req.URL.Host)
-			http.Error(rw, "Forbid", 403)
-			return true
+// bannedBy returns the rule denying d, or nil.
+func bannedBy(d *destination, url string) *banRule {
+	b := bans.Load()
+	var matched []*banRule
+	check := func(rules []*banRule) {
+		for _, r := range rules { //屏蔽列表，检查访问对象是否被屏蔽
+			if r.match(d, url) {
+				matched = append(matched, r)
+			}
+		}
+	}
+	check(b.hosts[d.host])
+	for h := d.host; h != ""; {
+		check(b.domains[h])
+		i := strings.Index(h, ".")
+		if i < 0 {
+			break
 		}
+		h = h[i+1:]
 	}
+	check(b.rules)
+	var denied *banRule
+	for _, r := range matched {
+		if r.allow {
+			return nil
+		}
+		if denied == nil {
+			denied = r
+		}
+	}
+	return denied
+}
 
-	return false
+func (proxy *Handler) ban(rw http.ResponseWriter, req *http.Request) bool {
+	d := destinationOf(req)
+	denied := bannedBy(d, urlOf(req, d))
+	if denied == nil {
+		return false
+	}
+	log.Infof("%s try to visit forbidden website %s, rule %s", proxy.User, req.URL.Host, denied.rule)
+	proxy.errorPage(rw, req, http.StatusForbidden, "blocked", denied.source+": "+denied.rule)
+	return true
 }
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"httpproxy/config"
+)
+
+func TestCompileBanRule(t *testing.T) {
+	tests := []struct {
+		rule   string
+		method string
+		target string
+		match  bool
+	}{
+		// Domains, with their subdomains
+		{"example.com", "GET", "http://example.com/", true},
+		{"example.com", "GET", "http://www.example.com/a", true},
+		{"example.com", "GET", "http://notexample.com/", false},
+		{"||example.com", "GET", "http://a.b.example.com/", true},
+		{"||example.com", "GET", "http://EXAMPLE.com./", true},
+		{"||example.com", "CONNECT", "example.com.:443", true},
+		// Only the host
+		{"=example.com", "GET", "http://example.com/", true},
+		{"=example.com", "GET", "http://www.example.com/", false},
+		// Wildcards
+		{"*.example.com", "GET", "http://a.example.com/", true},
+		{"*.example.com", "GET", "http://example.com/", false},
+		{"||ex*.com", "GET", "http://www.example.com/", true},
+		{"=a*.example.com", "GET", "http://abc.example.com/", true},
+		{"=a*.example.com", "GET", "http://x.abc.example.com/", false},
+		// URL prefixes and regexps
+		{"|http://example.com/path", "GET", "http://example.com/path/x", true},
+		{"|http://example.com/path", "GET", "http://example.com./path/x", true},
+		{"|http://example.com/path", "GET", "http://example.com/other", false},
+		{"|https://example.com/", "CONNECT", "example.com:443", true},
+		{"|https://example.com/", "CONNECT", "example.com:8443", false},
+		{`/^http:\/\/[0-9]+\.example\.com\//`, "GET", "http://42.example.com/", true},
+		{`/^http:\/\/[0-9]+\.example\.com\//`, "GET", "http://a.example.com/", false},
+		// Addresses and ports
+		{"10.0.0.0/8", "GET", "http://10.1.2.3/", true},
+		{"10.0.0.0/8", "GET", "http://192.0.2.1/", false},
+		{"192.0.2.1", "CONNECT", "192.0.2.1:443", true},
+		{"2001:db8::/32", "CONNECT", "[2001:db8::1]:443", true},
+		{":25", "CONNECT", "mail.example.com:25", true},
+		{":6660-6669", "CONNECT", "irc.example.com:6667", true},
+		{":6660-6669", "CONNECT", "irc.example.com:6670", false},
+		// Keywords
+		{"ads", "GET", "http://example.com/ads/banner", true},
+		{"ads", "GET", "http://example.com/", false},
+		// Schedules
+		{"example.com$schedule=00:00-24:00", "GET", "http://example.com/", true},
+	}
+	for _, tt := range tests {
+		r, err := compileBanRule(tt.rule)
+		if err != nil {
+			t.Errorf("compileBanRule(%q): %v", tt.rule, err)
+			continue
+		}
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.method == "CONNECT" {
+			req.URL.Host = tt.target
+		}
+		d := destinationOf(req)
+		if got := r.match(d, urlOf(req, d)); got != tt.match {
+			t.Errorf("rule %q on %s %s: match = %v, want %v", tt.rule, tt.method, tt.target, got, tt.match)
+		}
+	}
+}
+
+func TestCompileBanRuleAllow(t *testing.T) {
+	for _, s := range []string{"@@||example.com", "@@10.0.0.0/8", "@@:443"} {
+		r, err := compileBanRule(s)
+		if err != nil {
+			t.Fatalf("compileBanRule(%q): %v", s, err)
+		}
+		if !r.allow {
+			t.Errorf("rule %q does not allow", s)
+		}
+	}
+}
+
+func TestCompileBanRuleErrors(t *testing.T) {
+	for _, s := range []string{
+		"/(/",
+		":70000",
+		":9-1",
+		"10.0.0.0/99",
+		"example.com$schedule=noday",
+	} {
+		if _, err := compileBanRule(s); err == nil {
+			t.Errorf("compileBanRule(%q) succeeded", s)
+		}
+	}
+}
+
+func TestBanRuleIndex(t *testing.T) {
+	tests := []struct {
+		rule  string
+		index string
+		sub   bool
+	}{
+		{"example.com", "example.com", true},
+		{"||Example.com", "example.com", true},
+		{"=example.com", "example.com", false},
+		{"*.example.com", "example.com", true},
+		{"|http://Example.com/path", "example.com", false},
+		{"|https://example.com:8443/", "example.com", false},
+		{"@@|http://example.com/", "example.com", false},
+		{"example.com$schedule=00:00-24:00", "example.com", true},
+		// May match other hosts
+		{"|http://example.com", "", false},
+		{"|*://example.com/", "", false},
+		{"|http://*.example.com/", "", false},
+		{"|http://[2001:db8::1]/", "", false},
+		{"||ex*.com", "", false},
+		{"=a*.example.com", "", false},
+		{"*.example.*", "", false},
+		{`/example\.com/`, "", false},
+		{"10.0.0.0/8", "", false},
+		{"ads", "", false},
+	}
+	for _, tt := range tests {
+		r, err := compileBanRule(tt.rule)
+		if err != nil {
+			t.Errorf("compileBanRule(%q): %v", tt.rule, err)
+			continue
+		}
+		if r.index != tt.index || r.sub != tt.sub {
+			t.Errorf("rule %q: index %q, sub %v, want %q, %v", tt.rule, r.index, r.sub, tt.index, tt.sub)
+		}
+	}
+}
+
+func TestBannedBy(t *testing.T) {
+	useConfig(t, config.Config{GFWList: []string{
+		"||example.com",
+		"@@=ok.example.com",
+		"*.wild.org",
+		"|http://prefix.net/ads",
+		"@@|https://prefix.net/",
+		"=host.io",
+		"ads",
+	}})
+	tests := []struct {
+		method string
+		target string
+		rule   string // "" when not banned
+	}{
+		{"GET", "http://example.com/", "||example.com"},
+		{"GET", "http://a.b.example.com/", "||example.com"},
+		{"GET", "http://ok.example.com/", ""},
+		{"GET", "http://a.ok.example.com/", "||example.com"},
+		{"GET", "http://x.wild.org/", "*.wild.org"},
+		{"GET", "http://wild.org/", ""},
+		{"GET", "http://prefix.net/ads/1", "|http://prefix.net/ads"},
+		{"GET", "http://prefix.net/other", ""},
+		{"GET", "http://www.prefix.net/ads/1", "ads"},
+		{"CONNECT", "prefix.net:443", ""},
+		{"GET", "http://host.io/", "=host.io"},
+		{"GET", "http://www.host.io/", ""},
+		{"GET", "http://other.com/ads", "ads"},
+		{"GET", "http://other.com/", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.method == "CONNECT" {
+			req.URL.Host = tt.target
+		}
+		d := destinationOf(req)
+		rule := ""
+		if r := bannedBy(d, urlOf(req, d)); r != nil {
+			rule = r.rule
+		}
+		if rule != tt.rule {
+			t.Errorf("%s %s: banned by %q, want %q", tt.method, tt.target, rule, tt.rule)
+		}
+	}
+}
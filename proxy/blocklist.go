@@ -0,0 +1,373 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"httpproxy/config"
+)
+
+// maxBlocklistSize bounds the download of a list.
+const maxBlocklistSize = 64 << 20
+
+// blocklist is the state of a subscribed list.
+type blocklist struct {
+	config.Blocklist
+	entries      []string // as ban rules
+	etag         string
+	lastModified string
+	updated      time.Time // when the entries were last downloaded
+	checked      time.Time // when the source was last asked
+	err          string
+}
+
+// blocklistMeta is saved next to the cached copy of a list.
+type blocklistMeta struct {
+	ETag         string    `json:"etag"`
+	LastModified string    `json:"last_modified"`
+	Updated      time.Time `json:"updated"`
+}
+
+var (
+	blocklistsMu sync.Mutex
+	blocklists   []*blocklist
+	// refreshMu keeps refreshes from running at the same time.
+	refreshMu sync.Mutex
+)
+
+func init() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			refreshBlocklists("")
+		}
+	}()
+}
+
+func blocklistDir() string {
+	if dir := current.Load().cnfg.BlocklistDir; dir != "" {
+		return dir
+	}
+	return filepath.Join("config", "blocklists")
+}
+
+func (b *blocklist) interval() time.Duration {
+	if b.Interval > 0 {
+		return time.Duration(b.Interval) * time.Minute
+	}
+	return 24 * time.Hour
+}
+
+// initBlocklists sets up the blocklists of c. Lists that did not change
+// keep their entries, new ones start from the copy on disk and are
+// refreshed in the background.
+func initBlocklists(c *config.Config) {
+	blocklistsMu.Lock()
+	old := make(map[string]*blocklist)
+	for _, b := range blocklists {
+		old[b.Name] = b
+	}
+	lists := make([]*blocklist, 0, len(c.Blocklists))
+	for _, l := range c.Blocklists {
+		if b, ok := old[l.Name]; ok && b.Blocklist == l {
+			lists = append(lists, b)
+			continue
+		}
+		b := &blocklist{Blocklist: l}
+		b.loadCache()
+		lists = append(lists, b)
+	}
+	blocklists = lists
+	blocklistsMu.Unlock()
+
+	if len(lists) > 0 {
+		go refreshBlocklists("")
+	}
+}
+
+// blocklistEntries hands the rules of each list to add.
+func blocklistEntries(add func(source string, rules []string)) {
+	blocklistsMu.Lock()
+	defer blocklistsMu.Unlock()
+	for _, b := range blocklists {
+		add("blocklist "+b.Name, b.entries)
+	}
+}
+
+func (b *blocklist) cachePath() string {
+	return filepath.Join(blocklistDir(), b.Name+".txt")
+}
+
+// loadCache reads the last good copy of the list.
+func (b *blocklist) loadCache() {
+	body, err := os.ReadFile(b.cachePath())
+	if err != nil {
+		return
+	}
+	var meta blocklistMeta
+	if m, err := os.ReadFile(b.cachePath() + ".json"); err == nil {
+		json.Unmarshal(m, &meta)
+	}
+	entries, err := parseBlocklist(b.Format, body, b.Allow)
+	if err != nil {
+		log.Errorf("blocklist %s: cached copy: %v", b.Name, err)
+		return
+	}
+	b.entries, b.etag, b.lastModified, b.updated = entries, meta.ETag, meta.LastModified, meta.Updated
+}
+
+// saveCache keeps body as the last good copy of the list.
+func (b *blocklist) saveCache(body []byte) error {
+	if err := os.MkdirAll(blocklistDir(), 0755); err != nil {
+		return err
+	}
+	meta, _ := json.Marshal(blocklistMeta{b.etag, b.lastModified, b.updated})
+	if err := os.WriteFile(b.cachePath()+".tmp", body, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(b.cachePath()+".tmp", b.cachePath()); err != nil {
+		return err
+	}
+	return os.WriteFile(b.cachePath()+".json", meta, 0644)
+}
+
+var (
+	errNotModified = errors.New("not modified")
+	errTooLarge    = fmt.Errorf("list is larger than %d MB", maxBlocklistSize>>20)
+)
+
+// fetch gets the list from its URL or path, unless it did not change.
+func fetch(b blocklist) (body []byte, etag, lastModified string, err error) {
+	if !strings.Contains(b.URL, "://") {
+		fi, err := os.Stat(b.URL)
+		if err != nil {
+			return nil, "", "", err
+		}
+		lastModified = fi.ModTime().UTC().Format(http.TimeFormat)
+		if b.entries != nil && lastModified == b.lastModified {
+			return nil, "", "", errNotModified
+		}
+		if fi.Size() > maxBlocklistSize {
+			return nil, "", "", errTooLarge
+		}
+		body, err = os.ReadFile(b.URL)
+		return body, "", lastModified, err
+	}
+
+	req, err := http.NewRequest("GET", b.URL, nil)
+	if err != nil {
+		return nil, "", "", err
+	}
+	if b.entries != nil {
+		if b.etag != "" {
+			req.Header.Set("If-None-Match", b.etag)
+		}
+		if b.lastModified != "" {
+			req.Header.Set("If-Modified-Since", b.lastModified)
+		}
+	}
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, "", "", errNotModified
+	default:
+		return nil, "", "", fmt.Errorf("%s answered %s", b.URL, resp.Status)
+	}
+	// One byte more than allowed tells a list that was cut off.
+	body, err = io.ReadAll(io.LimitReader(resp.Body, maxBlocklistSize+1))
+	if err != nil {
+		return nil, "", "", err
+	}
+	if len(body) > maxBlocklistSize {
+		return nil, "", "", errTooLarge
+	}
+	return body, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), nil
+}
+
+// refreshBlocklists updates the lists that are due, or only the list
+// called name, right away. The ban list is rebuilt when any changed.
+func refreshBlocklists(name string) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	blocklistsMu.Lock()
+	lists := append([]*blocklist{}, blocklists...)
+	blocklistsMu.Unlock()
+
+	changed := false
+	for _, b := range lists {
+		blocklistsMu.Lock()
+		snapshot := *b
+		blocklistsMu.Unlock()
+		if name != "" && b.Name != name || name == "" && time.Since(snapshot.checked) < snapshot.interval() {
+			continue
+		}
+
+		body, etag, lastModified, err := fetch(snapshot)
+		var entries []string
+		if err == nil {
+			entries, err = parseBlocklist(b.Format, body, b.Allow)
+		}
+
+		blocklistsMu.Lock()
+		b.checked = time.Now()
+		switch {
+		case err == errNotModified:
+			b.err = ""
+		case err != nil:
+			// The last good copy stays in use.
+			b.err = err.Error()
+			log.Errorf("blocklist %s: %v", b.Name, err)
+		default:
+			b.entries, b.etag, b.lastModified, b.updated, b.err = entries, etag, lastModified, time.Now(), ""
+			if err := b.saveCache(body); err != nil {
+				log.Errorf("blocklist %s: %v", b.Name, err)
+			}
+			log.Infof("blocklist %s updated, %d entries", b.Name, len(entries))
+			changed = true
+		}
+		blocklistsMu.Unlock()
+	}
+	if changed {
+		initBanList()
+	}
+}
+
+// parseBlocklist turns a list in format into ban rules.
+func parseBlocklist(format string, body []byte, allow bool) ([]string, error) {
+	if format == "gfwlist" {
+		decoded, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(bytes.TrimSpace(body))))
+		if err != nil {
+			return nil, err
+		}
+		body, format = decoded, "adblock"
+	}
+
+	var entries []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if format != "adblock" {
+			line = strings.TrimSpace(strings.SplitN(line, "#", 2)[0])
+		}
+		if line == "" {
+			continue
+		}
+		switch format {
+		case "adblock":
+			// Comments, headers and element hiding rules
+			if strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") || strings.Contains(line, "##") {
+				continue
+			}
+			// Options and separators are not supported, the rule
+			// applies to every request.
+			if i := strings.Index(line, "$"); i > 0 && !(strings.HasPrefix(line, "/") && strings.HasSuffix(line, "/")) {
+				line = line[:i]
+			}
+			line = strings.TrimRight(line, "^|")
+			if line == "" || line == "@@" {
+				continue
+			}
+			// Exceptions of a deny list are meant for its own rules,
+			// but an allow rule overrides every deny rule, including
+			// the admin's own gfwlist. Only allow lists may allow.
+			if strings.HasPrefix(line, "@@") && !allow {
+				continue
+			}
+			entries = append(entries, line)
+		case "hosts":
+			fields := strings.Fields(line)
+			if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+				continue
+			}
+			for _, host := range fields[1:] {
+				switch host {
+				case "localhost", "localhost.localdomain", "local", "broadcasthost", "ip6-localhost", "ip6-loopback", "0.0.0.0":
+					continue
+				}
+				entries = append(entries, "="+host)
+			}
+		case "domains":
+			d := strings.TrimPrefix(strings.TrimPrefix(strings.Fields(line)[0], "*."), ".")
+			if domainLike.MatchString(strings.ToLower(d)) {
+				entries = append(entries, "||"+d)
+			}
+		case "cidr":
+			s := strings.Fields(line)[0]
+			if _, _, err := net.ParseCIDR(s); err == nil || net.ParseIP(s) != nil {
+				entries = append(entries, s)
+			}
+		default:
+			return nil, fmt.Errorf("unknown format %q", format)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no entries found")
+	}
+	if allow {
+		for i, e := range entries {
+			if !strings.HasPrefix(e, "@@") {
+				entries[i] = "@@" + e
+			}
+		}
+	}
+	return entries, nil
+}
+
+// BlocklistStatus describes a subscribed list.
+type BlocklistStatus struct {
+	Name    string
+	URL     string
+	Format  string
+	Allow   bool
+	Entries int
+	Updated time.Time
+	Checked time.Time
+	Error   string
+}
+
+// BlocklistStatuses returns the subscribed lists, in config order.
+func BlocklistStatuses() []BlocklistStatus {
+	blocklistsMu.Lock()
+	defer blocklistsMu.Unlock()
+	statuses := make([]BlocklistStatus, 0, len(blocklists))
+	for _, b := range blocklists {
+		statuses = append(statuses, BlocklistStatus{
+			Name:    b.Name,
+			URL:     b.URL,
+			Format:  b.Format,
+			Allow:   b.Allow,
+			Entries: len(b.entries),
+			Updated: b.updated,
+			Checked: b.checked,
+			Error:   b.err,
+		})
+	}
+	return statuses
+}
+
+// RefreshBlocklist updates the list called name now.
+func RefreshBlocklist(name string) {
+	refreshBlocklists(name)
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"reflect"
+	"testing"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	adblock := `[Adblock Plus 2.0]
+! Title: test
+||ads.example.com^
+|http://track.example.org/
+@@||good.example.com^
+example.net##.banner
+||opts.example.com^$third-party
+/banner[0-9]+/
+`
+	tests := []struct {
+		name   string
+		format string
+		body   string
+		allow  bool
+		want   []string
+	}{
+		{"hosts", "hosts", `# comment
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.com # trailing
+::1 ip6-localhost
+not-an-ip bad.example.com
+0.0.0.0 0.0.0.0
+`, false, []string{"=ads.example.com", "=tracker.example.com"}},
+		{"domains", "domains", `# comment
+example.com
+*.example.org
+.example.net
+not a domain!
+`, false, []string{"||example.com", "||example.org", "||example.net"}},
+		{"cidr", "cidr", `10.0.0.0/8
+192.0.2.1 # one address
+bad
+2001:db8::/32
+`, false, []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"}},
+		// Exceptions of a deny list must not allow anything.
+		{"adblock", "adblock", adblock, false,
+			[]string{"||ads.example.com", "|http://track.example.org/", "||opts.example.com", "/banner[0-9]+/"}},
+		{"adblock allow", "adblock", adblock, true,
+			[]string{"@@||ads.example.com", "@@|http://track.example.org/", "@@||good.example.com", "@@||opts.example.com", "@@/banner[0-9]+/"}},
+		{"gfwlist", "gfwlist", base64.StdEncoding.EncodeToString([]byte(adblock)) + "\n", false,
+			[]string{"||ads.example.com", "|http://track.example.org/", "||opts.example.com", "/banner[0-9]+/"}},
+		{"domains allow", "domains", "example.com\n", true, []string{"@@||example.com"}},
+	}
+	for _, tt := range tests {
+		got, err := parseBlocklist(tt.format, []byte(tt.body), tt.allow)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		for _, e := range got {
+			if _, err := compileBanRule(e); err != nil {
+				t.Errorf("%s: entry %q does not compile: %v", tt.name, e, err)
+			}
+		}
+	}
+}
+
+func TestParseBlocklistErrors(t *testing.T) {
+	tests := []struct {
+		format string
+		body   string
+	}{
+		{"unknown", "example.com\n"},
+		{"gfwlist", "not base64!\n"},
+		{"hosts", "# nothing\n"},
+		{"adblock", "! only comments\n@@||example.com^\n"},
+	}
+	for _, tt := range tests {
+		if _, err := parseBlocklist(tt.format, []byte(tt.body), false); err == nil {
+			t.Errorf("parseBlocklist(%q, %q) succeeded", tt.format, tt.body)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"httpproxy/lib"
 )
@@ -25,18 +26,25 @@ func (proxy *Handler) CacheHandler(rw http.ResponseWriter, req *http.Request) {
 	if c != nil {
 		if c.Verify() {
 			log.Debug("Get cache of %s", uri)
+			proxy.rec.Cache = "hit"
 			c.WriteTo(rw)
 			return
 		}
 
 		log.Debug("Delete cache of %s", uri)
+		proxy.rec.Cache = "revalidated"
 		cacheBox.Delete(uri)
 	}
 
+	if proxy.rec.Cache == "" {
+		proxy.rec.Cache = "miss"
+	}
 	RmProxyHeaders(req)
-	resp, err := proxy.Tr.RoundTrip(req)
+	s := proxy.shaperFor(req.URL.Host)
+	resp, err := proxy.roundTrip(req)
 	if err != nil {
-		http.Error(rw, err.Error(), 500)
+		log.Error(err)
+		proxy.upstreamError(rw, req, err)
 		return
 	}
 	defer resp.Body.Close()
@@ -53,7 +61,11 @@ func (proxy *Handler) CacheHandler(rw http.ResponseWriter, req *http.Request) {
 
 	rw.WriteHeader(resp.StatusCode) //写入响应状态
 
-	nr, err := io.Copy(rw, resp.Body)
+	if s.limited() {
+		// A throttled response may outlast the write timeout.
+		http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+	}
+	nr, err := io.Copy(s.writer(rw), resp.Body)
 	if err != nil && err != io.EOF {
 		log.Error("%v got an error when copy remote response to client.%v\n", proxy.User, err)
 		return
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"httpproxy/config"
+)
+
+// nginxWelcome is the page a fresh nginx install serves.
+const nginxWelcome = `<!DOCTYPE html>
+<html>
+<head>
+<title>Welcome to nginx!</title>
+<style>
+html { color-scheme: light dark; }
+body { width: 35em; margin: 0 auto;
+font-family: Tahoma, Verdana, Arial, sans-serif; }
+</style>
+</head>
+<body>
+<h1>Welcome to nginx!</h1>
+<p>If you see this page, the nginx web server is successfully installed and
+working. Further configuration is required.</p>
+
+<p>For online documentation and support please refer to
+<a href="http://nginx.org/">nginx.org</a>.<br/>
+Commercial support is available at
+<a href="http://nginx.com/">nginx.com</a>.</p>
+
+<p><em>Thank you for using nginx.</em></p>
+</body>
+</html>
+`
+
+// welcomeTime stands in for the mtime of the default page.
+var welcomeTime = time.Now().Add(-72 * time.Hour).Truncate(time.Second)
+
+// camouflaged reports whether AuthFailover should serve the decoy site.
+// Requests for the challenge host still get a 407, so clients that wait
+// for a challenge can log in.
+func camouflaged(cfg *config.Config, req *http.Request) bool {
+	c := cfg.Camouflage
+	if !c.Enable {
+		return false
+	}
+	return c.ChallengeHost == "" || !strings.EqualFold(req.URL.Hostname(), c.ChallengeHost)
+}
+
+// Camouflage answers req like an ordinary web server would.
+func Camouflage(cfg *config.Config, rw http.ResponseWriter, req *http.Request) {
+	c := cfg.Camouflage
+	server := c.Server
+	if server == "" {
+		server = "nginx"
+	}
+	rw.Header().Set("Server", server)
+
+	switch req.Method {
+	case "GET", "HEAD":
+	case "CONNECT":
+		// nginx cannot parse CONNECT and drops the connection.
+		if req.ProtoMajor == 1 {
+			rw.Header().Set("Connection", "close")
+		}
+		camouflageError(rw, http.StatusBadRequest)
+		return
+	default:
+		camouflageError(rw, http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Proxy requests carry an absolute URL; only its path matters here.
+	p := path.Clean("/" + req.URL.Path)
+	root := c.Root
+	if root == "" {
+		if p != "/" && p != "/index.html" {
+			camouflageError(rw, http.StatusNotFound)
+			return
+		}
+		serveCamouflage(rw, req, "index.html", welcomeTime, strings.NewReader(nginxWelcome), int64(len(nginxWelcome)))
+		return
+	}
+
+	name := filepath.Join(root, filepath.FromSlash(p))
+	fi, err := os.Stat(name)
+	if err == nil && fi.IsDir() {
+		// No autoindex, like nginx's default.
+		name = filepath.Join(name, "index.html")
+		fi, err = os.Stat(name)
+		if err != nil {
+			camouflageError(rw, http.StatusForbidden)
+			return
+		}
+	}
+	if err != nil || fi.IsDir() {
+		camouflageError(rw, http.StatusNotFound)
+		return
+	}
+
+	if c.Templates && strings.HasSuffix(name, ".html") {
+		t, err := template.ParseFiles(name)
+		if err != nil {
+			log.Errorf("camouflage: %v", err)
+			camouflageError(rw, http.StatusInternalServerError)
+			return
+		}
+		var b bytes.Buffer
+		err = t.Execute(&b, struct {
+			Host, Path string
+			Query      map[string][]string
+			Now        time.Time
+		}{req.Host, p, req.URL.Query(), time.Now()})
+		if err != nil {
+			log.Errorf("camouflage: %v", err)
+			camouflageError(rw, http.StatusInternalServerError)
+			return
+		}
+		rw.Header().Set("Content-Type", "text/html")
+		rw.Header().Set("Content-Length", fmt.Sprint(b.Len()))
+		rw.WriteHeader(http.StatusOK)
+		if req.Method == "GET" {
+			rw.Write(b.Bytes())
+		}
+		return
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		camouflageError(rw, http.StatusForbidden)
+		return
+	}
+	defer f.Close()
+	serveCamouflage(rw, req, name, fi.ModTime(), f, fi.Size())
+}
+
+// serveCamouflage serves a static file with the ETag nginx would give it.
+func serveCamouflage(rw http.ResponseWriter, req *http.Request, name string, modtime time.Time, content io.ReadSeeker, size int64) {
+	rw.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, modtime.Unix(), size))
+	// nginx's mime.types carry no charset.
+	if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+		rw.Header().Set("Content-Type", strings.SplitN(ctype, ";", 2)[0])
+	}
+	http.ServeContent(rw, req, name, modtime, content)
+}
+
+// camouflageError writes an nginx style error page.
+func camouflageError(rw http.ResponseWriter, code int) {
+	text := fmt.Sprintf("%d %s", code, http.StatusText(code))
+	if code == http.StatusMethodNotAllowed {
+		text = "405 Not Allowed"
+	}
+	body := "<html>\r\n<head><title>" + text + "</title></head>\r\n<body>\r\n<center><h1>" + text +
+		"</h1></center>\r\n<hr><center>" + rw.Header().Get("Server") + "</center>\r\n</body>\r\n</html>\r\n"
+	rw.Header().Set("Content-Type", "text/html")
+	rw.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	rw.WriteHeader(code)
+	rw.Write([]byte(body))
+}
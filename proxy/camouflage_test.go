@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"httpproxy/config"
+)
+
+func TestCamouflaged(t *testing.T) {
+	tests := []struct {
+		c      config.Camouflage
+		target string
+		want   bool
+	}{
+		{config.Camouflage{}, "http://example.com/", false},
+		{config.Camouflage{Enable: true}, "http://example.com/", true},
+		{config.Camouflage{Enable: true, ChallengeHost: "login.example.com"}, "http://example.com/", true},
+		{config.Camouflage{Enable: true, ChallengeHost: "login.example.com"}, "http://LOGIN.example.com:8080/", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		if got := camouflaged(&config.Config{Camouflage: tt.c}, req); got != tt.want {
+			t.Errorf("%+v on %s: got %v, want %v", tt.c, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestCamouflage(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "index.html"), []byte("<p>home</p>"), 0644)
+	os.WriteFile(filepath.Join(root, "app.js"), []byte("var x;"), 0644)
+	os.Mkdir(filepath.Join(root, "empty"), 0755)
+	os.WriteFile(filepath.Join(root, "hello.html"), []byte(`<p>{{.Host}} {{index .Query "name" 0}}</p>`), 0644)
+	os.WriteFile(filepath.Join(root, "bad.html"), []byte(`{{.Nothing}}`), 0644)
+	secret := filepath.Join(filepath.Dir(root), "secret.txt")
+	os.WriteFile(secret, []byte("secret"), 0644)
+	defer os.Remove(secret)
+
+	static := config.Camouflage{Enable: true, Root: root, Server: "Apache"}
+	templates := config.Camouflage{Enable: true, Root: root, Templates: true}
+	tests := []struct {
+		name   string
+		c      config.Camouflage
+		method string
+		target string
+		status int
+		body   string // contained in the body
+		server string
+	}{
+		{"welcome", config.Camouflage{Enable: true}, "GET", "http://example.com/", 200, "Welcome to nginx!", "nginx"},
+		{"welcome index", config.Camouflage{Enable: true}, "GET", "/index.html", 200, "Welcome to nginx!", "nginx"},
+		{"welcome, other", config.Camouflage{Enable: true}, "GET", "/x", 404, "<center>nginx</center>", "nginx"},
+		{"post", config.Camouflage{Enable: true}, "POST", "/", 405, "405 Not Allowed", "nginx"},
+		{"connect", config.Camouflage{Enable: true}, "CONNECT", "example.com:443", 400, "400 Bad Request", "nginx"},
+		{"root index", static, "GET", "/", 200, "<p>home</p>", "Apache"},
+		{"file", static, "GET", "http://example.com/app.js", 200, "var x;", "Apache"},
+		{"head", static, "HEAD", "/app.js", 200, "", "Apache"},
+		{"directory without index", static, "GET", "/empty/", 403, "403 Forbidden", "Apache"},
+		{"missing", static, "GET", "/missing", 404, "404 Not Found", "Apache"},
+		{"outside root", static, "GET", "/../secret.txt", 404, "404 Not Found", "Apache"},
+		{"not a template", static, "GET", "/hello.html", 200, "{{.Host}}", "Apache"},
+		{"template", templates, "GET", "http://example.com/hello.html?name=bob", 200, "<p>example.com bob</p>", "nginx"},
+		{"bad template", templates, "GET", "/bad.html", 500, "500 Internal Server Error", "nginx"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.method == "CONNECT" {
+			req.URL.Host = tt.target
+		}
+		if tt.target == "/../secret.txt" {
+			req.URL.Path = tt.target
+		}
+		rw := httptest.NewRecorder()
+		Camouflage(&config.Config{Camouflage: tt.c}, rw, req)
+		body := rw.Body.String()
+		if rw.Code != tt.status || !strings.Contains(body, tt.body) || rw.Header().Get("Server") != tt.server {
+			t.Errorf("%s: got %d %q (Server %s), want %d with %q (Server %s)",
+				tt.name, rw.Code, body, rw.Header().Get("Server"), tt.status, tt.body, tt.server)
+		}
+	}
+}
+
+func TestCamouflageETag(t *testing.T) {
+	rw := httptest.NewRecorder()
+	Camouflage(&config.Config{Camouflage: config.Camouflage{Enable: true}}, rw, httptest.NewRequest("GET", "/", nil))
+	etag := rw.Header().Get("ETag")
+	if etag == "" || rw.Header().Get("Content-Type") != "text/html" {
+		t.Fatalf("ETag %q, Content-Type %q", etag, rw.Header().Get("Content-Type"))
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("If-None-Match", etag)
+	rw = httptest.NewRecorder()
+	Camouflage(&config.Config{Camouflage: config.Camouflage{Enable: true}}, rw, req)
+	if rw.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match: status %d, want 304", rw.Code)
+	}
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"httpproxy/config"
+)
+
+// clientAuthTypes maps the clientauth listen option to its TLS mode.
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"request":        tls.RequestClientCert,
+	"require":        tls.RequireAnyClientCert,
+	"verify":         tls.VerifyClientCertIfGiven,
+	"require_verify": tls.RequireAndVerifyClientCert,
+}
+
+// setClientAuth applies the clientca and clientauth listen options.
+func setClientAuth(config *tls.Config, q url.Values) error {
+	mode := q.Get("clientauth")
+	if ca := q.Get("clientca"); ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("clientca %s: no certificates", ca)
+		}
+		config.ClientCAs = pool
+		if mode == "" {
+			mode = "verify"
+		}
+	}
+	if mode == "" {
+		return nil
+	}
+	t, ok := clientAuthTypes[mode]
+	if !ok {
+		return fmt.Errorf("unknown clientauth %q", mode)
+	}
+	if t >= tls.VerifyClientCertIfGiven && config.ClientCAs == nil {
+		return errors.New("clientauth " + mode + " needs clientca")
+	}
+	config.ClientAuth = t
+	return nil
+}
+
+// certUser returns the user named by the client's certificate, or "".
+// Only certificates verified against clientca count. The certuser
+// listen option picks the name: "cn" (default) or "san", the first
+// email, DNS or URI subject alternative name.
+func certUser(c *config.Config, req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return ""
+	}
+	cert := req.TLS.VerifiedChains[0][0]
+
+	field := ""
+	if listen, err := url.Parse(c.Listen); err == nil {
+		field = listen.Query().Get("certuser")
+	}
+	if field != "san" {
+		return cert.Subject.CommonName
+	}
+	switch {
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"httpproxy/config"
+)
+
+func TestCertUser(t *testing.T) {
+	uri, _ := url.Parse("spiffe://example.com/alice")
+	full := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "alice"},
+		EmailAddresses: []string{"alice@example.com"},
+		DNSNames:       []string{"alice.example.com"},
+		URIs:           []*url.URL{uri},
+	}
+	tests := []struct {
+		name     string
+		listen   string
+		cert     *x509.Certificate
+		verified bool
+		want     string
+	}{
+		{"no certificate", "https://:8443", nil, false, ""},
+		{"not verified", "https://:8443", full, false, ""},
+		{"cn by default", "https://:8443", full, true, "alice"},
+		{"cn", "https://:8443?certuser=cn", full, true, "alice"},
+		{"email first", "https://:8443?certuser=san", full, true, "alice@example.com"},
+		{"dns", "https://:8443?certuser=san", &x509.Certificate{DNSNames: full.DNSNames}, true, "alice.example.com"},
+		{"uri", "https://:8443?certuser=san", &x509.Certificate{URIs: full.URIs}, true, "spiffe://example.com/alice"},
+		{"no san", "https://:8443?certuser=san", &x509.Certificate{Subject: full.Subject}, true, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "https://example.com/", nil)
+		req.TLS = &tls.ConnectionState{}
+		if tt.cert != nil {
+			req.TLS.PeerCertificates = []*x509.Certificate{tt.cert}
+			if tt.verified {
+				req.TLS.VerifiedChains = [][]*x509.Certificate{{tt.cert}}
+			}
+		}
+		if got := certUser(&config.Config{Listen: tt.listen}, req); got != tt.want {
+			t.Errorf("%s: certUser = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if got := certUser(&config.Config{}, httptest.NewRequest("GET", "http://example.com/", nil)); got != "" {
+		t.Errorf("plain HTTP: certUser = %q", got)
+	}
+}
+
+// writeCA writes a self-signed CA certificate and returns its path.
+func writeCA(t *testing.T) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSetClientAuth(t *testing.T) {
+	ca := writeCA(t)
+	notPEM := filepath.Join(t.TempDir(), "ca.txt")
+	os.WriteFile(notPEM, []byte("nothing"), 0644)
+	tests := []struct {
+		query string
+		want  tls.ClientAuthType
+		err   bool
+	}{
+		{"", tls.NoClientCert, false},
+		{"clientauth=request", tls.RequestClientCert, false},
+		{"clientauth=require", tls.RequireAnyClientCert, false},
+		{"clientca=" + ca, tls.VerifyClientCertIfGiven, false},
+		{"clientca=" + ca + "&clientauth=require_verify", tls.RequireAndVerifyClientCert, false},
+		{"clientauth=verify", 0, true},
+		{"clientauth=require_verify", 0, true},
+		{"clientauth=always", 0, true},
+		{"clientca=" + notPEM, 0, true},
+		{"clientca=/nonexistent/ca.pem", 0, true},
+	}
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		c := &tls.Config{}
+		err := setClientAuth(c, q)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err = %v, want error %v", tt.query, err, tt.err)
+			continue
+		}
+		if err == nil && c.ClientAuth != tt.want {
+			t.Errorf("%s: ClientAuth = %v, want %v", tt.query, c.ClientAuth, tt.want)
+		}
+	}
+}
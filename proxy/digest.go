@@ -0,0 +1,276 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"httpproxy/config"
+)
+
+// nonceTTL is how long a Digest nonce may be used. Clients are then
+// told it is stale and retry with a new one.
+const nonceTTL = 5 * time.Minute
+
+// maxNonces bounds the nonces whose counts are remembered.
+const maxNonces = 4096
+
+// nonceWindow is how far behind the highest count a request may come,
+// as clients sending several requests at once do not keep their order.
+const nonceWindow = 64
+
+// A nonce is the time it was issued and a MAC of it, so nothing is
+// stored until a client answers it correctly.
+var nonceKey = make([]byte, 32)
+
+func init() {
+	rand.Read(nonceKey)
+}
+
+func newNonce() string {
+	b := make([]byte, 8, 8+sha256.Size)
+	binary.BigEndian.PutUint64(b, uint64(time.Now().UnixNano()))
+	m := hmac.New(sha256.New, nonceKey)
+	m.Write(b)
+	return hex.EncodeToString(m.Sum(b)[:24])
+}
+
+// nonceIssued returns when n was issued, unless it was not issued here.
+func nonceIssued(n string) (time.Time, bool) {
+	b, err := hex.DecodeString(n)
+	if err != nil || len(b) != 24 {
+		return time.Time{}, false
+	}
+	m := hmac.New(sha256.New, nonceKey)
+	m.Write(b[:8])
+	if !hmac.Equal(m.Sum(nil)[:16], b[8:]) {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b[:8]))), true
+}
+
+// nonceUse remembers the counts seen with a nonce, to refuse replays:
+// the highest one and which of the nonceWindow before it were used.
+type nonceUse struct {
+	issued time.Time
+	max    uint64
+	seen   uint64 // bit i is max-i
+}
+
+var (
+	noncesMu sync.Mutex
+	nonces   = make(map[string]*nonceUse)
+	// nonceFloor is when the newest nonce forgotten to make room was
+	// issued. Nonces up to it are stale, their counts are gone.
+	nonceFloor time.Time
+)
+
+var errStale = errors.New("stale nonce")
+
+// useNonce accounts a use of n with count nc. A nonce which is unknown,
+// too old or whose count was seen before is stale: the client is asked
+// to retry with a new one.
+func useNonce(n string, nc uint64) error {
+	issued, ok := nonceIssued(n)
+	if !ok || nc == 0 || time.Since(issued) > nonceTTL {
+		return errStale
+	}
+
+	noncesMu.Lock()
+	defer noncesMu.Unlock()
+	u, ok := nonces[n]
+	if !ok {
+		if !issued.After(nonceFloor) {
+			return errStale
+		}
+		if len(nonces) >= maxNonces {
+			forgetNonces()
+		}
+		u = &nonceUse{issued: issued}
+		nonces[n] = u
+	}
+	switch {
+	case nc > u.max:
+		if d := nc - u.max; d < nonceWindow {
+			u.seen <<= d
+		} else {
+			u.seen = 0
+		}
+		u.seen |= 1
+		u.max = nc
+	case u.max-nc >= nonceWindow || u.seen&(1<<(u.max-nc)) != 0:
+		return errStale
+	default:
+		u.seen |= 1 << (u.max - nc)
+	}
+	return nil
+}
+
+// forgetNonces makes room in nonces: expired ones go first, otherwise
+// the oldest. noncesMu must be held.
+func forgetNonces() {
+	var oldest string
+	for k, v := range nonces {
+		if time.Since(v.issued) > nonceTTL {
+			delete(nonces, k)
+		} else if oldest == "" || v.issued.Before(nonces[oldest].issued) {
+			oldest = k
+		}
+	}
+	if len(nonces) >= maxNonces {
+		nonceFloor = nonces[oldest].issued
+		delete(nonces, oldest)
+	}
+}
+
+// proxyChallenge builds the 407 response, with a challenge for every
+// enabled scheme. Digest offers SHA-256 first and MD5 for older clients.
+// The body is the auth error page.
+func (s *state) proxyChallenge(req *http.Request, stale bool) []byte {
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 407 Proxy Authorization Required\r\n")
+	if s.cnfg.DigestEnabled() {
+		n := newNonce()
+		for _, alg := range []string{"SHA-256", "MD5"} {
+			b.WriteString(`Proxy-Authenticate: Digest realm="` + config.DigestRealm +
+				`", qop="auth", algorithm=` + alg + `, nonce="` + n + `"`)
+			if stale {
+				b.WriteString(", stale=true")
+			}
+			b.WriteString("\r\n")
+		}
+	}
+	if s.cnfg.BasicEnabled() {
+		b.WriteString(`Proxy-Authenticate: Basic realm="` + config.DigestRealm + "\"\r\n")
+	}
+	e := newErrorData(req, http.StatusProxyAuthRequired, "auth", "", "")
+	body, ctype := renderErrorPage(req, e)
+	b.WriteString("Content-Type: " + ctype + "\r\nX-Request-Id: " + e.RequestID + "\r\n")
+	// NeedAuth closes the connection after the challenge.
+	b.WriteString("Connection: close\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n")
+	b.Write(body)
+	return []byte(b.String())
+}
+
+// parseDigest splits the parameters of a Digest authorization.
+func parseDigest(s string) map[string]string {
+	params := make(map[string]string)
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+		i := strings.Index(s, "=")
+		if i < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = s[i+1:]
+		var val string
+		if strings.HasPrefix(s, `"`) {
+			j := 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				break
+			}
+			val = strings.ReplaceAll(s[1:j], `\`, "")
+			s = s[j+1:]
+		} else {
+			j := strings.Index(s, ",")
+			if j < 0 {
+				j = len(s)
+			}
+			val = strings.TrimSpace(s[:j])
+			s = s[j:]
+		}
+		params[key] = val
+	}
+	return params
+}
+
+// digestHA1 returns H(user:realm:password) for alg. Digests are only
+// used while the user exists.
+func (s *state) digestHA1(user, alg string) (string, bool) {
+	passwd, ok := s.lookupUser(user)
+	if !ok {
+		return "", false
+	}
+	if d, ok := s.cnfg.Digests[user]; ok {
+		if alg == "SHA-256" && d.SHA256 != "" {
+			return d.SHA256, true
+		}
+		if alg == "MD5" && d.MD5 != "" {
+			return d.MD5, true
+		}
+	}
+	// A plaintext password, e.g. from a user file, can be used directly.
+	if !config.IsHashed(passwd) {
+		d := config.NewDigest(user, passwd)
+		if alg == "SHA-256" {
+			return d.SHA256, true
+		}
+		return d.MD5, true
+	}
+	return "", false
+}
+
+// checkDigest verifies a Digest authorization and returns the user.
+func (s *state) checkDigest(req *http.Request, authorization string) (string, error) {
+	p := parseDigest(authorization)
+	user := p["username"]
+
+	alg := p["algorithm"]
+	var h func() hash.Hash
+	switch strings.ToUpper(alg) {
+	case "", "MD5":
+		alg, h = "MD5", md5.New
+	case "SHA-256":
+		alg, h = "SHA-256", sha256.New
+	default:
+		return "", errors.New("unsupported digest algorithm " + alg)
+	}
+	if p["realm"] != config.DigestRealm || p["qop"] != "auth" {
+		return "", errors.New("bad digest parameters")
+	}
+	// Clients send either the request target or just its path.
+	if uri := p["uri"]; uri != req.RequestURI && uri != req.Host && uri != req.URL.RequestURI() {
+		return "", errors.New("digest uri does not match the request")
+	}
+	nc, err := strconv.ParseUint(p["nc"], 16, 64)
+	if err != nil {
+		return "", errors.New("bad nonce count")
+	}
+
+	ha1, ok := s.digestHA1(user, alg)
+	if !ok {
+		return "", errors.New("no digest for user " + user)
+	}
+	H := func(s string) string {
+		d := h()
+		d.Write([]byte(s))
+		return hex.EncodeToString(d.Sum(nil))
+	}
+	ha2 := H(req.Method + ":" + p["uri"])
+	want := H(ha1 + ":" + p["nonce"] + ":" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+	if subtle.ConstantTimeCompare([]byte(want), []byte(p["response"])) != 1 {
+		return "", errors.New("wrong digest response")
+	}
+	// Only a correct response may use up a nonce count.
+	if err := useNonce(p["nonce"], nc); err != nil {
+		return user, err
+	}
+	return user, nil
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"httpproxy/config"
+)
+
+func TestParseDigest(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]string
+	}{
+		{`username="alice", realm="Secure Proxys", nc=00000001, qop=auth`,
+			map[string]string{"username": "alice", "realm": "Secure Proxys", "nc": "00000001", "qop": "auth"}},
+		{`USERNAME="a\"b",uri="/x,y"`, map[string]string{"username": `a"b`, "uri": "/x,y"}},
+		{`algorithm=SHA-256 , cnonce="c"`, map[string]string{"algorithm": "SHA-256", "cnonce": "c"}},
+		{`username="unterminated`, map[string]string{}},
+		{`garbage`, map[string]string{}},
+		{``, map[string]string{}},
+	}
+	for _, tt := range tests {
+		if got := parseDigest(tt.in); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseDigest(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUseNonce(t *testing.T) {
+	n := newNonce()
+	tests := []struct {
+		nc uint64
+		ok bool
+	}{
+		{1, true},
+		{3, true},
+		{2, true}, // out of order, but within the window
+		{2, false},
+		{3, false},
+		{100, true},
+		{100 - nonceWindow + 1, true},
+		{100 - nonceWindow, false}, // too far behind
+		{0, false},
+	}
+	for _, tt := range tests {
+		if err := useNonce(n, tt.nc); (err == nil) != tt.ok {
+			t.Errorf("nc %d: err = %v, want ok %v", tt.nc, err, tt.ok)
+		}
+	}
+	forged := n[:len(n)-1] + "0"
+	if forged == n {
+		forged = n[:len(n)-1] + "1"
+	}
+	if err := useNonce(forged, 1); err != errStale {
+		t.Errorf("forged nonce: err = %v, want %v", err, errStale)
+	}
+	if err := useNonce("0011", 1); err != errStale {
+		t.Errorf("short nonce: err = %v, want %v", err, errStale)
+	}
+}
+
+func TestCheckDigest(t *testing.T) {
+	s := &state{cnfg: config.Config{User: map[string]string{"alice": "secret"}}}
+	H := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	nonce := newNonce()
+	authorization := func(passwd, uri, nc string) string {
+		ha1 := H("alice:" + config.DigestRealm + ":" + passwd)
+		resp := H(ha1 + ":" + nonce + ":" + nc + ":c0ffee:auth:" + H("GET:"+uri))
+		return strings.Join([]string{
+			`username="alice"`, `realm="` + config.DigestRealm + `"`, `nonce="` + nonce + `"`,
+			`uri="` + uri + `"`, `qop=auth`, `nc=` + nc, `cnonce="c0ffee"`, `response="` + resp + `"`,
+		}, ", ")
+	}
+	req := httptest.NewRequest("GET", "http://example.com/a?b", nil)
+
+	tests := []struct {
+		name          string
+		authorization string
+		err           bool
+	}{
+		{"absolute uri", authorization("secret", "http://example.com/a?b", "00000001"), false},
+		{"path", authorization("secret", "/a?b", "00000002"), false},
+		{"replayed count", authorization("secret", "/a?b", "00000002"), true},
+		{"wrong password", authorization("wrong", "/a?b", "00000003"), true},
+		{"other uri", authorization("secret", "/c", "00000004"), true},
+	}
+	for _, tt := range tests {
+		user, err := s.checkDigest(req, tt.authorization)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.err)
+		}
+		if err == nil && user != "alice" {
+			t.Errorf("%s: user = %q", tt.name, user)
+		}
+	}
+}
+
+func TestDigestHA1(t *testing.T) {
+	s := &state{cnfg: config.Config{
+		User: map[string]string{
+			"alice": "$2a$10$xoxSvFA/eZB5g1RzWuGElOD95VaZchHP4kNjTu8E3/9qQoThJYK6e",
+			"bob":   "plain",
+		},
+		Digests: map[string]config.Digest{
+			"alice": config.NewDigest("alice", "secret"),
+			// Left over from a deleted user
+			"carol": config.NewDigest("carol", "secret"),
+		},
+	}}
+	tests := []struct {
+		user, alg string
+		want      string // "" when there is none
+	}{
+		{"alice", "MD5", config.NewDigest("alice", "secret").MD5},
+		{"alice", "SHA-256", config.NewDigest("alice", "secret").SHA256},
+		{"bob", "MD5", config.NewDigest("bob", "plain").MD5},
+		{"bob", "SHA-256", config.NewDigest("bob", "plain").SHA256},
+		{"carol", "MD5", ""},
+		{"nobody", "MD5", ""},
+	}
+	for _, tt := range tests {
+		got, ok := s.digestHA1(tt.user, tt.alg)
+		if ok != (tt.want != "") || got != tt.want {
+			t.Errorf("digestHA1(%q, %s) = %q, %v, want %q", tt.user, tt.alg, got, ok, tt.want)
+		}
+	}
+}
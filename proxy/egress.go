@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+
+	"httpproxy/config"
+)
+
+// errEgress is returned when the egress guard refuses a destination.
+var errEgress = errors.New("destination not allowed")
+
+// privateNets are denied unless allowed explicitly: this host, private
+// networks, link-local (cloud metadata), CGNAT, multicast and reserved.
+var privateNets = []string{
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+	"169.254.0.0/16", "172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16",
+	"198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+}
+
+// egressPolicy is the egress config ready for matching.
+type egressPolicy struct {
+	allow, deny  []*net.IPNet
+	ports        []portRange
+	connectPorts []portRange
+}
+
+// compileEgress compiles c.Egress. Bad entries are logged and skipped.
+// It returns nil when the guard is disabled.
+func compileEgress(c *config.Config) *egressPolicy {
+	if c.Egress.Disable {
+		return nil
+	}
+	e := &egressPolicy{}
+	parse := func(cidrs []string) []*net.IPNet {
+		var nets []*net.IPNet
+		for _, cidr := range cidrs {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				log.Errorf("egress: %v", err)
+				continue
+			}
+			nets = append(nets, n)
+		}
+		return nets
+	}
+	e.allow = parse(c.Egress.Allow)
+	e.deny = parse(append(append([]string{}, privateNets...), c.Egress.Deny...))
+	// The addresses of this host, so its own ports cannot be reached
+	// through a public address either.
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if n, ok := a.(*net.IPNet); ok {
+				bits := 8 * len(n.IP)
+				e.deny = append(e.deny, &net.IPNet{IP: n.IP, Mask: net.CIDRMask(bits, bits)})
+			}
+		}
+	}
+	ports := func(list []string) []portRange {
+		var ranges []portRange
+		for _, p := range list {
+			lo, hi, err := config.ParsePortRange(p)
+			if err != nil {
+				log.Errorf("egress: %v", err)
+				continue
+			}
+			ranges = append(ranges, portRange{lo, hi})
+		}
+		return ranges
+	}
+	e.ports = ports(c.Egress.Ports)
+	e.connectPorts = ports(c.Egress.ConnectPorts)
+	return e
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *egressPolicy) ipAllowed(ip net.IP) bool {
+	return contains(e.allow, ip) || !contains(e.deny, ip)
+}
+
+func (e *egressPolicy) portAllowed(port int, connect bool) bool {
+	ranges := e.ports
+	if connect && len(e.connectPorts) > 0 {
+		ranges = e.connectPorts
+	}
+	if len(ranges) == 0 {
+		return true
+	}
+	for _, r := range ranges {
+		if port >= r.lo && port <= r.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// control checks the address a dialer is about to connect to, after
+// DNS resolution, so a name cannot be rebound to a forbidden address.
+// It is the dialer's Control function.
+func (e *egressPolicy) control(network, address string, c syscall.RawConn) error {
+	if e == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !e.ipAllowed(ip) {
+		return fmt.Errorf("%w: %s", errEgress, address)
+	}
+	return nil
+}
+
+// egressExempt marks requests for reverse proxy backends, which are
+// configured by the admin and commonly private.
+type egressExempt struct{}
+
+// dialDirect connects to addr itself, checked by the egress guard.
+func (proxy *Handler) dialDirect(addr string) (net.Conn, error) {
+	d := proxy.d
+	d.Control = proxy.s.egress.control
+	return d.Dial("tcp", addr)
+}
+
+// dialContext is the Transport's DialContext. Connections to parents and
+// reverse proxy backends are not checked, everything else is.
+func (proxy *Handler) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := proxy.d
+	p, viaParent := ctx.Value(parentKey{}).(*parent)
+	if ctx.Value(egressExempt{}) == nil && !(viaParent && p != direct && p.url.Host == addr) {
+		d.Control = stateOf(ctx).egress.control
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
+// Egress refuses destinations the guard does not allow with a 403 before
+// anything is sent, and returns true then. The dialer checks again.
+func (proxy *Handler) Egress(rw http.ResponseWriter, req *http.Request) bool {
+	e := proxy.s.egress
+	if e == nil {
+		return false
+	}
+	d := destinationOf(req)
+	reason := ""
+	if !e.portAllowed(d.port, req.Method == "CONNECT") {
+		reason = fmt.Sprintf("port %d", d.port)
+	}
+	if reason == "" {
+		// A name which does not resolve fails in the dialer.
+		ips, _ := d.addrs()
+		for _, ip := range ips {
+			if !e.ipAllowed(ip) {
+				reason = "address " + ip.String()
+				break
+			}
+		}
+	}
+	if reason == "" {
+		return false
+	}
+	log.Warningf("%s: %s %s refused by egress guard, %s", proxy.User, req.Method, req.URL.Host, reason)
+	proxy.errorPage(rw, req, http.StatusForbidden, "blocked", "egress: "+reason)
+	return true
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"httpproxy/config"
+)
+
+func TestEgressIPAllowed(t *testing.T) {
+	e := compileEgress(&config.Config{Egress: config.Egress{
+		Allow: []string{"10.1.0.0/16"},
+		Deny:  []string{"198.51.100.0/24"},
+	}})
+	tests := []struct {
+		ip    string
+		allow bool
+	}{
+		{"127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"10.1.2.3", true}, // allowed over the private default
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false}, // cloud metadata
+		{"100.64.0.1", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"198.51.100.7", false},
+		{"203.0.113.9", true},
+		{"2001:4860:4860::8888", true},
+	}
+	for _, tt := range tests {
+		if got := e.ipAllowed(net.ParseIP(tt.ip)); got != tt.allow {
+			t.Errorf("ipAllowed(%s) = %v, want %v", tt.ip, got, tt.allow)
+		}
+	}
+	if compileEgress(&config.Config{Egress: config.Egress{Disable: true}}) != nil {
+		t.Error("disabled guard compiled")
+	}
+}
+
+func TestEgressPortAllowed(t *testing.T) {
+	tests := []struct {
+		ports, connectPorts []string
+		port                int
+		connect             bool
+		allow               bool
+	}{
+		{nil, nil, 25, false, true},
+		{[]string{"80", "443"}, nil, 80, false, true},
+		{[]string{"80", "443"}, nil, 25, false, false},
+		{[]string{"80", "443"}, nil, 443, true, true},
+		{[]string{"80", "1024-65535"}, nil, 8080, false, true},
+		{[]string{"80"}, []string{"443"}, 443, true, true},
+		{[]string{"80"}, []string{"443"}, 80, true, false},
+		{[]string{"80"}, []string{"443"}, 443, false, false},
+	}
+	for _, tt := range tests {
+		e := compileEgress(&config.Config{Egress: config.Egress{Ports: tt.ports, ConnectPorts: tt.connectPorts}})
+		if got := e.portAllowed(tt.port, tt.connect); got != tt.allow {
+			t.Errorf("ports %v, connect ports %v: portAllowed(%d, %v) = %v, want %v",
+				tt.ports, tt.connectPorts, tt.port, tt.connect, got, tt.allow)
+		}
+	}
+}
+
+func TestEgressControl(t *testing.T) {
+	e := compileEgress(&config.Config{})
+	tests := []struct {
+		address string
+		ok      bool
+	}{
+		{"203.0.113.9:443", true},
+		{"127.0.0.1:22", false},
+		{"[::1]:22", false},
+		{"localhost:22", false}, // not resolved yet, refused
+		{"nonsense", false},
+	}
+	for _, tt := range tests {
+		err := e.control("tcp", tt.address, nil)
+		if (err == nil) != tt.ok {
+			t.Errorf("control(%s) = %v, want ok %v", tt.address, err, tt.ok)
+		}
+		if err != nil && tt.address != "nonsense" && !errors.Is(err, errEgress) {
+			t.Errorf("control(%s) = %v, not an egress error", tt.address, err)
+		}
+	}
+	if err := (*egressPolicy)(nil).control("tcp", "127.0.0.1:22", nil); err != nil {
+		t.Errorf("disabled guard: %v", err)
+	}
+}
+
+func TestEgress(t *testing.T) {
+	s := newState(config.Config{Egress: config.Egress{Ports: []string{"80", "443"}}}, nil)
+	tests := []struct {
+		method, target string
+		rule           string // "" when allowed
+	}{
+		{"GET", "http://203.0.113.9/", ""},
+		{"CONNECT", "203.0.113.9:443", ""},
+		{"GET", "http://127.0.0.1/", "egress: address 127.0.0.1"},
+		{"GET", "http://169.254.169.254/latest/meta-data/", "egress: address 169.254.169.254"},
+		{"CONNECT", "[::1]:443", "egress: address ::1"},
+		{"CONNECT", "203.0.113.9:22", "egress: port 22"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.method == "CONNECT" {
+			req.URL.Host = tt.target
+		}
+		rw := httptest.NewRecorder()
+		refused := (&Handler{s: s}).Egress(rw, req)
+		if refused != (tt.rule != "") {
+			t.Errorf("%s %s: refused %v, want %v", tt.method, tt.target, refused, tt.rule != "")
+			continue
+		}
+		if !refused {
+			continue
+		}
+		var e errorData
+		json.Unmarshal(rw.Body.Bytes(), &e)
+		if rw.Code != http.StatusForbidden || e.Rule != tt.rule {
+			t.Errorf("%s %s: answered %d %q, want rule %q", tt.method, tt.target, rw.Code, e.Rule, tt.rule)
+		}
+	}
+}
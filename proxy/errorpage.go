@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// errorPageFile is the template shared by all kinds of error page.
+const errorPageFile = "views/errors/layout.tpl"
+
+// errorKind is the text of one kind of error page.
+type errorKind struct {
+	Title   string
+	Message string
+}
+
+// errorKinds are the kinds of error page and their text.
+var errorKinds = map[string]errorKind{
+	"blocked":  {"访问被禁止", "管理员不允许访问该目标"},
+	"auth":     {"需要代理认证", "请使用代理账号登入"},
+	"upstream": {"无法连接目标服务器", "代理服务器无法连接目标服务器"},
+	"timeout":  {"连接目标服务器超时", "代理服务器连接目标服务器超时"},
+	"quota":    {"超出使用限额", "已超出使用限额，请稍后再试"},
+}
+
+// errorData is given to the error page templates, and is the JSON
+// variant of the page.
+type errorData struct {
+	Status     int       `json:"status"`
+	StatusText string    `json:"status_text"`
+	Kind       string    `json:"error"`
+	Title      string    `json:"-"`
+	Message    string    `json:"message"`
+	User       string    `json:"user,omitempty"`
+	Target     string    `json:"target"`
+	Rule       string    `json:"rule,omitempty"`
+	RequestID  string    `json:"request_id"`
+	Time       time.Time `json:"time"`
+}
+
+// recordKey is the context key of the access record of a request.
+type recordKey struct{}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newErrorData(req *http.Request, code int, kind, user, rule string) *errorData {
+	e := &errorData{
+		Status:     code,
+		StatusText: http.StatusText(code),
+		Kind:       kind,
+		Title:      errorKinds[kind].Title,
+		Message:    errorKinds[kind].Message,
+		User:       user,
+		Target:     req.Host,
+		Rule:       rule,
+		Time:       time.Now(),
+	}
+	if rec, ok := req.Context().Value(recordKey{}).(*AccessRecord); ok {
+		e.RequestID, e.Target = rec.ID, rec.Target
+	}
+	return e
+}
+
+// wantsHTML tells browsers from other clients, which get JSON.
+func wantsHTML(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+// errorPages holds the parsed error page template, nil when it does not
+// parse and errors get the JSON variant.
+var errorPages atomic.Pointer[template.Template]
+
+// errorPagesLoaded tells a template which failed to parse from one not
+// loaded yet.
+var errorPagesLoaded atomic.Bool
+
+// loadErrorPages parses the error page template.
+func loadErrorPages() *template.Template {
+	t, err := template.ParseFiles(errorPageFile)
+	if err != nil {
+		log.Errorf("error page: %v", err)
+		t = nil
+	}
+	errorPages.Store(t)
+	errorPagesLoaded.Store(true)
+	return t
+}
+
+func errorPageTemplate() *template.Template {
+	if errorPagesLoaded.Load() {
+		return errorPages.Load()
+	}
+	return loadErrorPages()
+}
+
+// renderErrorPage returns the page for e and its content type.
+func renderErrorPage(req *http.Request, e *errorData) ([]byte, string) {
+	if wantsHTML(req) {
+		if t := errorPageTemplate(); t != nil {
+			var b bytes.Buffer
+			err := t.Execute(&b, e)
+			if err == nil {
+				return b.Bytes(), "text/html; charset=utf-8"
+			}
+			log.Errorf("error page %s: %v", e.Kind, err)
+		}
+	}
+	b, _ := json.Marshal(e)
+	return append(b, '\n'), "application/json"
+}
+
+// errorPage answers req with the error page of kind.
+func (proxy *Handler) errorPage(rw http.ResponseWriter, req *http.Request, code int, kind, rule string) {
+	writeErrorPage(rw, req, code, kind, proxy.User, rule)
+}
+
+// writeErrorPage answers req with the error page of kind, for user.
+func writeErrorPage(rw http.ResponseWriter, req *http.Request, code int, kind, user, rule string) {
+	e := newErrorData(req, code, kind, user, rule)
+	body, ctype := renderErrorPage(req, e)
+	h := rw.Header()
+	h.Set("Content-Type", ctype)
+	h.Set("Content-Length", fmt.Sprint(len(body)))
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Request-Id", e.RequestID)
+	rw.WriteHeader(code)
+	rw.Write(body)
+}
+
+// upstreamError answers a failure to reach the target with a timeout or
+// upstream page, or a blocked one for the egress guard.
+func (proxy *Handler) upstreamError(rw http.ResponseWriter, req *http.Request, err error) {
+	code, kind, rule := upstreamStatus(err)
+	proxy.errorPage(rw, req, code, kind, rule)
+}
+
+func upstreamStatus(err error) (int, string, string) {
+	if errors.Is(err, errEgress) {
+		return http.StatusForbidden, "blocked", "egress"
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return http.StatusGatewayTimeout, "timeout", ""
+	}
+	return http.StatusBadGateway, "upstream", ""
+}
+
+// withRecord makes the access record of req available to error pages,
+// including those written before the user is known.
+func withRecord(req *http.Request, rec *AccessRecord) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), recordKey{}, rec))
+}
+
+// ErrorPage returns the error page template.
+func ErrorPage() (string, error) {
+	b, err := os.ReadFile(errorPageFile)
+	return string(b), err
+}
+
+// SetErrorPage checks the error page template against every kind of
+// error page and saves it.
+func SetErrorPage(text string) error {
+	t, err := template.New(filepath.Base(errorPageFile)).Parse(text)
+	if err != nil {
+		return err
+	}
+	for _, kind := range ErrorKinds() {
+		sample := &errorData{Status: 403, StatusText: "Forbidden", Kind: kind,
+			Title: errorKinds[kind].Title, Message: errorKinds[kind].Message,
+			User: "user", Target: "example.com:443", Rule: "rule", RequestID: newRequestID(), Time: time.Now()}
+		if err := t.Execute(new(bytes.Buffer), sample); err != nil {
+			return fmt.Errorf("%s: %v", kind, err)
+		}
+	}
+	if err := os.WriteFile(errorPageFile, []byte(text), 0644); err != nil {
+		return err
+	}
+	errorPages.Store(t)
+	errorPagesLoaded.Store(true)
+	return nil
+}
+
+// ErrorKinds returns the kinds of error page, sorted.
+func ErrorKinds() []string {
+	kinds := make([]string, 0, len(errorKinds))
+	for kind := range errorKinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
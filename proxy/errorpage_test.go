@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	kinds := ErrorKinds()
+	if len(kinds) != len(errorKinds) || !sort.StringsAreSorted(kinds) {
+		t.Fatalf("ErrorKinds() = %q", kinds)
+	}
+	for _, kind := range kinds {
+		if k := errorKinds[kind]; k.Title == "" || k.Message == "" {
+			t.Errorf("%s has no text", kind)
+		}
+	}
+}
+
+func TestRenderErrorPage(t *testing.T) {
+	page := template.Must(template.ParseFiles("../" + errorPageFile))
+	errorPages.Store(page)
+	errorPagesLoaded.Store(true)
+	t.Cleanup(func() {
+		errorPages.Store(nil)
+		errorPagesLoaded.Store(false)
+	})
+
+	tests := []struct {
+		kind   string
+		code   int
+		accept string
+		ctype  string
+	}{
+		{"blocked", http.StatusForbidden, "text/html,application/xhtml+xml", "text/html; charset=utf-8"},
+		{"auth", http.StatusProxyAuthRequired, "text/html", "text/html; charset=utf-8"},
+		{"upstream", http.StatusBadGateway, "text/html", "text/html; charset=utf-8"},
+		{"timeout", http.StatusGatewayTimeout, "*/*", "application/json"},
+		{"quota", http.StatusTooManyRequests, "", "application/json"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set("Accept", tt.accept)
+		rw := httptest.NewRecorder()
+		writeErrorPage(rw, req, tt.code, tt.kind, "alice", "rule")
+		body := rw.Body.String()
+		if rw.Code != tt.code || rw.Header().Get("Content-Type") != tt.ctype {
+			t.Errorf("%s: got %d %s, want %d %s", tt.kind, rw.Code, rw.Header().Get("Content-Type"), tt.code, tt.ctype)
+			continue
+		}
+		if tt.ctype != "application/json" {
+			for _, s := range []string{errorKinds[tt.kind].Title, errorKinds[tt.kind].Message, "alice", "example.com"} {
+				if !strings.Contains(body, s) {
+					t.Errorf("%s: page does not contain %q", tt.kind, s)
+				}
+			}
+			continue
+		}
+		var e errorData
+		if err := json.Unmarshal([]byte(body), &e); err != nil {
+			t.Errorf("%s: %v", tt.kind, err)
+			continue
+		}
+		if e.Kind != tt.kind || e.Message != errorKinds[tt.kind].Message || e.User != "alice" || e.Rule != "rule" {
+			t.Errorf("%s: got %+v", tt.kind, e)
+		}
+	}
+}
+
+func TestSetErrorPageErrors(t *testing.T) {
+	for _, text := range []string{
+		"{{.Title",
+		"{{.Nothing}}",
+		`{{if eq .Kind "quota"}}{{.Time.Nothing}}{{end}}`,
+	} {
+		if err := SetErrorPage(text); err == nil {
+			t.Errorf("SetErrorPage(%q) succeeded", text)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"net/http"
+	"strings"
 )
 
 // CopyHeaders copy headers from source to destination.
@@ -30,6 +31,22 @@ func SanitizeRequest(req *http.Request) {
 	}
 }
 
+// IsUpgrade reports whether req asks to switch protocols,
+// e.g. a WebSocket or h2c handshake.
+func IsUpgrade(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range req.Header["Connection"] {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // RmProxyHeaders remove Hop-by-hop headers.
 func RmProxyHeaders(req *http.Request) {
 	req.RequestURI = ""
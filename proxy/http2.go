@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// h2Stream turns an HTTP/2 request body and its ResponseWriter into a
+// connection, so a stream can be spliced like a hijacked connection.
+type h2Stream struct {
+	body io.ReadCloser
+	rw   http.ResponseWriter
+}
+
+func (s *h2Stream) Read(b []byte) (int, error) {
+	return s.body.Read(b)
+}
+
+func (s *h2Stream) Write(b []byte) (int, error) {
+	n, err := s.rw.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, http.NewResponseController(s.rw).Flush()
+}
+
+func (s *h2Stream) Close() error {
+	return s.body.Close()
+}
+
+// streamFor prepares a long-lived HTTP/2 stream after its response
+// header has been written. The server timeouts, meant for requests,
+// would otherwise reset the stream.
+func streamFor(rw http.ResponseWriter, body io.ReadCloser) *h2Stream {
+	rc := http.NewResponseController(rw)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	rc.Flush()
+	return &h2Stream{body: body, rw: rw}
+}
+
+// http2Tunnel handles a CONNECT request received over HTTP/2.
+// The tunnel is carried by the stream, no hijacking is involved.
+func (proxy *Handler) http2Tunnel(rw http.ResponseWriter, req *http.Request, boost200 bool) {
+	log.Infof("%s tried to connect to %s over HTTP/2", proxy.User, req.URL.Host)
+
+	if boost200 {
+		// 提前发送200，减少RTT时间
+		rw.WriteHeader(http.StatusOK)
+	}
+	remote, err := proxy.dial(req.URL.Host) //建立服务端和代理服务器的tcp连接
+	if err != nil {
+		log.Errorf("%s failed to connect %s", proxy.User, req.RequestURI)
+		if !boost200 {
+			proxy.upstreamError(rw, req, err)
+		}
+		return
+	}
+	if !boost200 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	defer proxy.watchTunnel(req, remote)()
+
+	s := proxy.shaperFor(req.URL.Host)
+	splice(proxy.User, req.URL.Host, s.conn(proxy.metered(streamFor(rw, req.Body))), remote)
+}
+
+// fromExtendedConnect turns an RFC 8441 extended CONNECT into the
+// HTTP/1.1 upgrade request it stands for, so it can be handled by
+// UpgradeHandler. It reports whether req was such a request.
+func fromExtendedConnect(req *http.Request) bool {
+	protocol := req.Header.Get(":protocol")
+	if req.Method != "CONNECT" || protocol == "" {
+		return false
+	}
+	req.Header.Del(":protocol")
+	req.Method = "GET"
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", protocol)
+	// WebSocket over HTTP/2 has no key, but HTTP/1.1 backends require one.
+	if strings.EqualFold(protocol, "websocket") && req.Header.Get("Sec-WebSocket-Key") == "" {
+		key := make([]byte, 16)
+		rand.Read(key)
+		req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	}
+	return true
+}
+
+// toAbsolute fills in the target of an HTTP/2 request, which comes in
+// origin form with the host in :authority, so the filters see the same
+// URL as for an HTTP/1.1 proxy request.
+func toAbsolute(req *http.Request) {
+	if req.ProtoMajor != 2 || req.Method == "CONNECT" || req.URL.Host != "" {
+		return
+	}
+	req.URL.Host = req.Host
+	if req.URL.Scheme == "" {
+		req.URL.Scheme = "http"
+	}
+}
+
+// writeRawResponse writes a canned raw response such as a 407 challenge through
+// rw, for connections that cannot be hijacked.
+func writeRawResponse(rw http.ResponseWriter, raw []byte) error {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(raw)), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	CopyHeaders(rw.Header(), resp.Header)
+	rw.WriteHeader(resp.StatusCode)
+	_, err = io.Copy(rw, resp.Body)
+	return err
+}
+
+var failoverTransport = &http.Transport{}
+
+// http2Failover relays a request received over HTTP/2 to the failover
+// server as a plain HTTP/1.1 request.
+func http2Failover(failover string, rw http.ResponseWriter, req *http.Request) {
+	if req.Method == "CONNECT" {
+		writeErrorPage(rw, req, http.StatusBadRequest, "auth", "", "")
+		return
+	}
+	req.URL.Scheme = "http"
+	req.URL.Host = failover
+	req.RequestURI = ""
+	resp, err := failoverTransport.RoundTrip(req)
+	if err != nil {
+		log.Errorf("%s failed set up a connection to failover server.", "Unauthorized")
+		writeErrorPage(rw, req, http.StatusBadGateway, "upstream", "", "failover")
+		return
+	}
+	defer resp.Body.Close()
+	CopyHeaders(rw.Header(), resp.Header)
+	rw.WriteHeader(resp.StatusCode)
+	io.Copy(rw, resp.Body)
+}
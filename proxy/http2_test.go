@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"httpproxy/config"
+)
+
+// useConfig makes c the current config for the rest of the test.
+func useConfig(t *testing.T, c config.Config) *state {
+	t.Helper()
+	old := current.Load()
+	s := newState(c, nil)
+	current.Store(s)
+	initBanList()
+	t.Cleanup(func() {
+		current.Store(old)
+		initBanList()
+	})
+	return s
+}
+
+// serve runs req through a Handler and returns the status and the rule
+// of the error page.
+func serve(req *http.Request) (int, string) {
+	rw := httptest.NewRecorder()
+	(&Handler{Tr: &http.Transport{}}).ServeHTTP(rw, req)
+	var e errorData
+	json.Unmarshal(rw.Body.Bytes(), &e)
+	return rw.Code, e.Rule
+}
+
+func TestHTTP2Filters(t *testing.T) {
+	useConfig(t, config.Config{
+		GFWList: []string{"||blocked.com"},
+		ACL: config.ACL{Rules: []config.ACLRule{
+			{Name: "no-denied", Action: "deny", Domains: []string{"denied.com"}},
+		}},
+	})
+	tests := []struct {
+		name   string
+		method string
+		target string // as in the request line
+		host   string // :authority
+		status int
+		rule   string
+	}{
+		{"ban", "GET", "/", "blocked.com", http.StatusForbidden, "gfwlist: ||blocked.com"},
+		{"ban with port", "POST", "/x", "www.blocked.com:8080", http.StatusForbidden, "gfwlist: ||blocked.com"},
+		{"ban connect", "CONNECT", "blocked.com:443", "blocked.com:443", http.StatusForbidden, "gfwlist: ||blocked.com"},
+		{"acl", "GET", "/", "denied.com", http.StatusForbidden, "acl: no-denied"},
+		// Passes the ban list and ACL, the egress guard stops it.
+		{"allowed", "GET", "/", "127.0.0.1", http.StatusForbidden, "egress: address 127.0.0.1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		req.ProtoMajor, req.ProtoMinor, req.Proto = 2, 0, "HTTP/2.0"
+		req.Host = tt.host
+		if tt.method == "CONNECT" {
+			req.URL.Host = tt.host
+		}
+		status, rule := serve(req)
+		if status != tt.status || rule != tt.rule {
+			t.Errorf("%s: got %d %q, want %d %q", tt.name, status, rule, tt.status, tt.rule)
+		}
+	}
+}
@@ -6,12 +6,11 @@ import (
 )
 
 var log = logging.MustGetLogger("proxy")
-var cnfg config.Config
 
 //setLog() sets log output format.
-func setLog() {
+func setLog(c *config.Config) {
 	var level logging.Level
-	if cnfg.Log == 1 {
+	if c.Log == 1 {
 		level = logging.DEBUG
 	} else {
 		level = logging.INFO
@@ -29,6 +28,8 @@ func setLog() {
 
 // Initialize the Proxy
 func Initialize(c config.Config) {
-	cnfg = c
-	setLog()
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	publish(c)
+	setLog(&c)
 }
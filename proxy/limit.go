@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"httpproxy/config"
+)
+
+// counter tracks what one user or client IP is doing.
+type counter struct {
+	tunnels  int64
+	requests int64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a request token, reporting how long to wait when
+// there is none left.
+func (c *counter) allow(rps float64, burst int) (bool, time.Duration) {
+	if rps <= 0 {
+		return true, 0
+	}
+	size := float64(burst)
+	if size <= 0 {
+		size = math.Max(rps, 1)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if c.last.IsZero() {
+		c.tokens = size
+	} else {
+		c.tokens = math.Min(size, c.tokens+now.Sub(c.last).Seconds()*rps)
+	}
+	c.last = now
+	if c.tokens < 1 {
+		return false, time.Duration((1 - c.tokens) / rps * float64(time.Second))
+	}
+	c.tokens--
+	return true, 0
+}
+
+// idle reports whether the counter can be dropped.
+func (c *counter) idle() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return atomic.LoadInt64(&c.tunnels) == 0 &&
+		atomic.LoadInt64(&c.requests) == 0 &&
+		time.Since(c.last) > time.Minute
+}
+
+var (
+	countersMu sync.Mutex
+	counters   = make(map[string]*counter)
+)
+
+// takeCounter takes a tunnel or request from the counter of key. It is
+// done under countersMu, so dropIdleCounters cannot drop the counter
+// before it is in use.
+func takeCounter(key string, l config.Limits, tunnel bool) (func(), error) {
+	countersMu.Lock()
+	defer countersMu.Unlock()
+	c, ok := counters[key]
+	if !ok {
+		c = &counter{}
+		counters[key] = c
+	}
+	return c.take(key, l, tunnel)
+}
+
+// dropIdleCounters forgets the counters nobody is using.
+func dropIdleCounters() {
+	countersMu.Lock()
+	defer countersMu.Unlock()
+	for key, c := range counters {
+		if c.idle() {
+			delete(counters, key)
+		}
+	}
+}
+
+func init() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			dropIdleCounters()
+		}
+	}()
+}
+
+// errLimited describes a refused request.
+type errLimited struct {
+	key        string
+	retryAfter time.Duration
+}
+
+func (e *errLimited) Error() string {
+	return e.key + " is over its limit"
+}
+
+// take accounts one tunnel or request against c, undoing it on failure.
+func (c *counter) take(key string, l config.Limits, tunnel bool) (func(), error) {
+	if ok, wait := c.allow(l.RPS, l.Burst); !ok {
+		return nil, &errLimited{key, wait}
+	}
+	active, max := &c.requests, l.Requests
+	if tunnel {
+		active, max = &c.tunnels, l.Tunnels
+	}
+	if n := atomic.AddInt64(active, 1); max > 0 && n > max {
+		atomic.AddInt64(active, -1)
+		return nil, &errLimited{key, time.Second}
+	}
+	return func() { atomic.AddInt64(active, -1) }, nil
+}
+
+// Limit enforces the concurrency and request-rate limits of the user
+// and the client IP. It answers 429 and returns false when a limit is
+// hit; otherwise the returned function must be called once the request
+// or tunnel is done.
+func (proxy *Handler) Limit(rw http.ResponseWriter, req *http.Request) (func(), bool) {
+	tunnel := req.Method == "CONNECT" || IsUpgrade(req)
+
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	keys := []string{"ip:" + ip}
+	limits := []config.Limits{proxy.s.cnfg.IPLimits}
+	// Anonymous requests are only limited per IP.
+	if proxy.User != "Anonymous" {
+		keys = append(keys, "user:"+proxy.User)
+		limits = append(limits, proxy.s.cnfg.ProfileOf(proxy.User).Limits)
+	}
+
+	releases := make([]func(), 0, len(keys))
+	release := func() {
+		for _, r := range releases {
+			r()
+		}
+	}
+	for i, key := range keys {
+		r, err := takeCounter(key, limits[i], tunnel)
+		if err != nil {
+			release()
+			e := err.(*errLimited)
+			log.Infof("%s: %s %s refused, %v", proxy.User, req.Method, req.Host, err)
+			retry := int(math.Ceil(e.retryAfter.Seconds()))
+			if retry < 1 {
+				retry = 1
+			}
+			rw.Header().Set("Retry-After", strconv.Itoa(retry))
+			proxy.errorPage(rw, req, http.StatusTooManyRequests, "quota", "limit: "+key)
+			return nil, false
+		}
+		releases = append(releases, r)
+	}
+	return release, true
+}
+
+// LimitStatus describes the counters of a user or client IP.
+type LimitStatus struct {
+	Key      string
+	Tunnels  int64
+	Requests int64
+}
+
+// LimitStatuses returns the current counters, sorted by key.
+func LimitStatuses() []LimitStatus {
+	countersMu.Lock()
+	defer countersMu.Unlock()
+	statuses := make([]LimitStatus, 0, len(counters))
+	for key, c := range counters {
+		statuses = append(statuses, LimitStatus{
+			Key:      key,
+			Tunnels:  atomic.LoadInt64(&c.tunnels),
+			Requests: atomic.LoadInt64(&c.requests),
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+	return statuses
+}
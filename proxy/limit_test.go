@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"testing"
+
+	"httpproxy/config"
+)
+
+func TestCounterTake(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits config.Limits
+		tunnel bool
+		n      int // taken without releasing
+		ok     int // how many of them succeed
+	}{
+		{"no limits", config.Limits{}, false, 10, 10},
+		{"requests", config.Limits{Requests: 3}, false, 5, 3},
+		{"tunnels", config.Limits{Tunnels: 2}, true, 5, 2},
+		{"tunnels do not count requests", config.Limits{Requests: 1}, true, 5, 5},
+		{"rate with burst", config.Limits{RPS: 0.001, Burst: 4}, false, 6, 4},
+		{"rate without burst", config.Limits{RPS: 0.001}, false, 3, 1},
+	}
+	for _, tt := range tests {
+		c := &counter{}
+		ok := 0
+		for i := 0; i < tt.n; i++ {
+			if _, err := c.take("k", tt.limits, tt.tunnel); err == nil {
+				ok++
+			}
+		}
+		if ok != tt.ok {
+			t.Errorf("%s: %d of %d taken, want %d", tt.name, ok, tt.n, tt.ok)
+		}
+	}
+}
+
+func TestCounterRelease(t *testing.T) {
+	c := &counter{}
+	l := config.Limits{Requests: 1}
+	release, err := c.take("k", l, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.take("k", l, false); err == nil {
+		t.Fatal("second request taken")
+	}
+	release()
+	if _, err := c.take("k", l, false); err != nil {
+		t.Errorf("after release: %v", err)
+	}
+}
+
+func TestDropIdleCounters(t *testing.T) {
+	// Without a rate limit the counter never records a time, so only
+	// its use keeps it from being dropped.
+	l := config.Limits{Tunnels: 1}
+	release, err := takeCounter("test:drop", l, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropIdleCounters()
+	if _, err := takeCounter("test:drop", l, true); err == nil {
+		t.Error("counter in use was dropped")
+	}
+	release()
+	dropIdleCounters()
+	countersMu.Lock()
+	_, ok := counters["test:drop"]
+	countersMu.Unlock()
+	if ok {
+		t.Error("idle counter was kept")
+	}
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// attempts tracks the failed logins of a client IP or username.
+type attempts struct {
+	failures    int
+	last        time.Time
+	bans        int // bans so far, each one twice as long
+	bannedUntil time.Time
+}
+
+var (
+	attemptsMu   sync.Mutex
+	failedLogins = make(map[string]*attempts)
+)
+
+func init() {
+	go func() {
+		for range time.Tick(time.Minute) {
+			now := time.Now()
+			window := current.Load().lockoutWindow()
+			attemptsMu.Lock()
+			for key, a := range failedLogins {
+				if now.After(a.bannedUntil) && now.Sub(a.last) > window {
+					delete(failedLogins, key)
+				}
+			}
+			attemptsMu.Unlock()
+		}
+	}()
+}
+
+func (s *state) lockoutWindow() time.Duration {
+	if s.cnfg.Lockout.Window > 0 {
+		return time.Duration(s.cnfg.Lockout.Window) * time.Second
+	}
+	return 15 * time.Minute
+}
+
+// clientIP returns the address of the client without the port.
+func clientIP(req *http.Request) string {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return ip
+}
+
+// lockoutKeys returns the keys a login is tracked under.
+func lockoutKeys(ip, user string) []string {
+	keys := []string{"ip:" + ip}
+	if user != "" {
+		keys = append(keys, "user:"+user)
+	}
+	return keys
+}
+
+// lockedOut reports whether any of keys is banned.
+func (s *state) lockedOut(keys ...string) bool {
+	if s.cnfg.Lockout.Threshold <= 0 {
+		return false
+	}
+	now := time.Now()
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	for _, key := range keys {
+		if a, ok := failedLogins[key]; ok && now.Before(a.bannedUntil) {
+			return true
+		}
+	}
+	return false
+}
+
+// loginFailed counts a failed login, banning the keys that reach the
+// threshold.
+func (s *state) loginFailed(keys ...string) {
+	l := s.cnfg.Lockout
+	if l.Threshold <= 0 {
+		return
+	}
+	base := time.Duration(l.Duration) * time.Second
+	if base <= 0 {
+		base = time.Minute
+	}
+	max := time.Duration(l.MaxDuration) * time.Second
+	if max <= 0 {
+		max = time.Hour
+	}
+
+	now := time.Now()
+	window := s.lockoutWindow()
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	for _, key := range keys {
+		a, ok := failedLogins[key]
+		if !ok || now.Sub(a.last) > window && now.After(a.bannedUntil) {
+			a = &attempts{}
+			failedLogins[key] = a
+		}
+		a.failures++
+		a.last = now
+		if a.failures < l.Threshold {
+			continue
+		}
+		d := base << uint(a.bans)
+		if d > max || d <= 0 {
+			d = max
+		}
+		a.bannedUntil = now.Add(d)
+		a.bans++
+		a.failures = 0
+		log.Warningf("%s banned for %v after failed logins", key, d)
+	}
+}
+
+// loginSucceeded forgets the failures of keys.
+func loginSucceeded(keys ...string) {
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	for _, key := range keys {
+		if a, ok := failedLogins[key]; ok && time.Now().After(a.bannedUntil) {
+			delete(failedLogins, key)
+		}
+	}
+}
+
+// LiftBan removes the ban and failures of key.
+func LiftBan(key string) {
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	delete(failedLogins, key)
+}
+
+// BanStatus describes a client IP or username with failed logins.
+type BanStatus struct {
+	Key         string
+	Failures    int
+	Bans        int
+	BannedUntil time.Time
+	Banned      bool
+}
+
+// BanStatuses returns the tracked keys, sorted by key.
+func BanStatuses() []BanStatus {
+	now := time.Now()
+	attemptsMu.Lock()
+	defer attemptsMu.Unlock()
+	statuses := make([]BanStatus, 0, len(failedLogins))
+	for key, a := range failedLogins {
+		statuses = append(statuses, BanStatus{
+			Key:         key,
+			Failures:    a.failures,
+			Bans:        a.bans,
+			BannedUntil: a.bannedUntil,
+			Banned:      now.Before(a.bannedUntil),
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Key < statuses[j].Key })
+	return statuses
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"httpproxy/config"
+)
+
+func TestLockoutBackoff(t *testing.T) {
+	s := &state{cnfg: config.Config{Lockout: config.Lockout{Threshold: 2, Duration: 60, MaxDuration: 300}}}
+	const key = "ip:192.0.2.10"
+	t.Cleanup(func() { LiftBan(key) })
+	tests := []struct {
+		failures int // failed logins before the check
+		locked   bool
+		ban      time.Duration // the ban they lead to, 0 for none
+	}{
+		{1, false, 0},
+		{1, true, time.Minute},
+		{1, true, time.Minute}, // counted, but not enough for another ban
+		{1, true, 2 * time.Minute},
+		{2, true, 4 * time.Minute},
+		{2, true, 5 * time.Minute},
+		{2, true, 5 * time.Minute},
+	}
+	for i, tt := range tests {
+		for n := 0; n < tt.failures; n++ {
+			s.loginFailed(key)
+		}
+		if got := s.lockedOut("user:nobody", key); got != tt.locked {
+			t.Errorf("step %d: locked out %v, want %v", i, got, tt.locked)
+		}
+		attemptsMu.Lock()
+		a := failedLogins[key]
+		ban := time.Duration(0)
+		if a.bans > 0 {
+			ban = a.bannedUntil.Sub(a.last).Round(time.Second)
+		}
+		attemptsMu.Unlock()
+		if tt.ban != 0 && ban != tt.ban {
+			t.Errorf("step %d: banned for %v, want %v", i, ban, tt.ban)
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+	keys := lockoutKeys("192.0.2.11", "mallory")
+	t.Cleanup(func() {
+		for _, key := range keys {
+			LiftBan(key)
+		}
+	})
+	off := &state{}
+	on := &state{cnfg: config.Config{Lockout: config.Lockout{Threshold: 3}}}
+
+	for i := 0; i < 5; i++ {
+		off.loginFailed(keys...)
+	}
+	if off.lockedOut(keys...) || len(BanStatuses()) != 0 {
+		t.Error("failures counted without a threshold")
+	}
+
+	on.loginFailed(keys...)
+	on.loginFailed(keys...)
+	loginSucceeded(keys...)
+	on.loginFailed(keys...)
+	on.loginFailed(keys...)
+	if on.lockedOut(keys...) {
+		t.Error("locked out although a success came in between")
+	}
+	on.loginFailed(keys...)
+	for _, key := range keys {
+		if !on.lockedOut(key) {
+			t.Errorf("%s not locked out", key)
+		}
+	}
+	// Logging in does not lift a ban, the admin does.
+	loginSucceeded(keys...)
+	if !on.lockedOut(keys...) {
+		t.Error("ban lifted by a login")
+	}
+	if off.lockedOut(keys...) {
+		t.Error("locked out without a threshold")
+	}
+	statuses := BanStatuses()
+	if len(statuses) != 2 || statuses[0].Key != "ip:192.0.2.11" || !statuses[1].Banned || statuses[1].Bans != 1 {
+		t.Errorf("BanStatuses() = %+v", statuses)
+	}
+	LiftBan(keys[0])
+	if !on.lockedOut(keys...) || on.lockedOut(keys[0]) {
+		t.Error("LiftBan lifted the wrong ban")
+	}
+}
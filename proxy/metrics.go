@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"httpproxy/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	activeTunnels = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "httpproxy_active_tunnels",
+		Help: "Tunnels currently open.",
+	})
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "httpproxy_requests_total",
+		Help: "Requests and tunnels handled, by method and status.",
+	}, []string{"method", "code"})
+	userBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "httpproxy_user_bytes_total",
+		Help: "Bytes transferred per user; direction is up or down.",
+	}, []string{"user", "direction"})
+	authFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "httpproxy_auth_failures_total",
+		Help: "Failed proxy authentications.",
+	})
+	cacheResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "httpproxy_cache_total",
+		Help: "Cache lookups; result is hit, miss or revalidated.",
+	}, []string{"result"})
+	dialSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "httpproxy_upstream_dial_seconds",
+		Help:    "Time taken to connect the upstream of a tunnel.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"result"})
+
+	registry = prometheus.NewRegistry()
+)
+
+func init() {
+	registry.MustRegister(
+		activeTunnels, requestsTotal, userBytes, authFailures, cacheResults, dialSeconds,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// methodLabel returns the method as a label. Clients choose the method,
+// so any other one is counted as "OTHER" to bound the series.
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH":
+		return method
+	}
+	return "OTHER"
+}
+
+// observe feeds a finished access record into the metrics.
+func observe(r *AccessRecord) {
+	requestsTotal.WithLabelValues(methodLabel(r.Method), strconv.Itoa(r.Status)).Inc()
+	if r.User != "" {
+		userBytes.WithLabelValues(r.User, "up").Add(float64(r.BytesIn))
+		userBytes.WithLabelValues(r.User, "down").Add(float64(r.BytesOut))
+	}
+	if r.Auth == "failure" {
+		authFailures.Inc()
+	}
+	if r.Cache != "" {
+		cacheResults.WithLabelValues(r.Cache).Inc()
+	}
+}
+
+var metricsHandler = promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+
+// MetricsHandler serves /metrics. It has its own credentials, so a
+// scraper does not need the admin password.
+func (ws *WebServer) MetricsHandler(rw http.ResponseWriter, req *http.Request) {
+	m := ws.s.cnfg.Metrics
+	if !m.Enable {
+		http.NotFound(rw, req)
+		return
+	}
+	if !metricsAuthorized(m, req) {
+		rw.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+		http.Error(rw, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	metricsHandler.ServeHTTP(rw, req)
+}
+
+func metricsAuthorized(m config.Metrics, req *http.Request) bool {
+	if m.User == "" && m.Token == "" {
+		return true
+	}
+	if m.Token != "" {
+		auth := req.Header.Get("Authorization")
+		if strings.HasPrefix(auth, "Bearer ") && equal(auth[len("Bearer "):], m.Token) {
+			return true
+		}
+	}
+	if m.User != "" && m.Password != "" {
+		user, passwd, ok := req.BasicAuth()
+		if ok && equal(user, m.User) && equal(passwd, m.Password) {
+			return true
+		}
+	}
+	return false
+}
+
+// equal compares secrets in constant time.
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
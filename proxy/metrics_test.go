@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"httpproxy/config"
+)
+
+func TestMetricsAuthorized(t *testing.T) {
+	basic := config.Metrics{User: "prom", Password: "secret"}
+	bearer := config.Metrics{Token: "t0ken"}
+	tests := []struct {
+		name   string
+		m      config.Metrics
+		user   string // "" for no Basic credentials
+		passwd string
+		bearer string // "" for no Bearer token
+		ok     bool
+	}{
+		{"open", config.Metrics{}, "", "", "", true},
+		{"basic", basic, "prom", "secret", "", true},
+		{"basic, wrong password", basic, "prom", "wrong", "", false},
+		{"basic, other user", basic, "other", "secret", "", false},
+		{"basic, nothing sent", basic, "", "", "", false},
+		{"bearer", bearer, "", "", "t0ken", true},
+		{"bearer, wrong token", bearer, "", "", "wrong", false},
+		{"bearer, basic sent", bearer, "prom", "t0ken", "", false},
+		// Rejected by config.Check, and never open if it gets here.
+		{"user without password", config.Metrics{User: "prom"}, "prom", "", "", false},
+		{"user without password, other user", config.Metrics{User: "prom"}, "x", "", "", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if tt.user != "" {
+			req.SetBasicAuth(tt.user, tt.passwd)
+		}
+		if tt.bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.bearer)
+		}
+		if got := metricsAuthorized(tt.m, req); got != tt.ok {
+			t.Errorf("%s: authorized = %v, want %v", tt.name, got, tt.ok)
+		}
+	}
+}
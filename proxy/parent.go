@@ -0,0 +1,288 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	xproxy "golang.org/x/net/proxy"
+
+	"httpproxy/config"
+)
+
+// parentDownTime is how long a failed parent is tried last.
+const parentDownTime = 30 * time.Second
+
+// errRefused means a parent is up but refused the tunnel.
+var errRefused = errors.New("tunnel refused")
+
+// parent is an upstream proxy. A nil url means a direct connection.
+type parent struct {
+	name string
+	url  *url.URL
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+func (p *parent) healthy() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Now().After(p.downUntil)
+}
+
+func (p *parent) fail(err error) {
+	log.Warningf("parent proxy %s failed: %v", p.name, err)
+	p.mu.Lock()
+	p.downUntil = time.Now().Add(parentDownTime)
+	p.mu.Unlock()
+}
+
+type parentRule struct {
+	domains []string
+	nets    []*net.IPNet
+	parents []*parent
+}
+
+var direct = &parent{name: "direct"}
+
+// compileParents parses c.Parents and c.ParentRules.
+// Bad entries are logged and skipped.
+func compileParents(c *config.Config) []*parentRule {
+	parents := map[string]*parent{"direct": direct}
+	for name, raw := range c.Parents {
+		u, err := url.Parse(raw)
+		if err != nil {
+			log.Errorf("parent %s: %v", name, err)
+			continue
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			log.Errorf("parent %s: unsupported scheme %q", name, u.Scheme)
+			continue
+		}
+		parents[name] = &parent{name: name, url: u}
+	}
+
+	var parentRules []*parentRule
+	for i, r := range c.ParentRules {
+		rule := &parentRule{domains: r.Domains}
+		for _, cidr := range r.CIDRs {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				log.Errorf("parent rule %d: %v", i, err)
+				continue
+			}
+			rule.nets = append(rule.nets, n)
+		}
+		for _, name := range r.Parents {
+			p, ok := parents[name]
+			if !ok {
+				log.Errorf("parent rule %d: unknown parent %s", i, name)
+				continue
+			}
+			rule.parents = append(rule.parents, p)
+		}
+		if len(rule.parents) > 0 {
+			parentRules = append(parentRules, rule)
+		}
+	}
+	return parentRules
+}
+
+func (r *parentRule) match(host string, ips []net.IP) bool {
+	host = strings.ToLower(host)
+	for _, d := range r.domains {
+		d = strings.ToLower(d)
+		if d == "*" || host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	for _, n := range r.nets {
+		for _, ip := range ips {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parentsFor returns the parents to try for host, healthy ones first.
+// It returns nil when host should be reached directly.
+func (s *state) parentsFor(host string) []*parent {
+	if len(s.parentRules) == 0 {
+		return nil
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	}
+	resolved := ips != nil
+	for _, r := range s.parentRules {
+		if len(r.nets) > 0 && !resolved {
+			// Only resolve when a rule needs the address.
+			ips, _ = net.LookupIP(host)
+			resolved = true
+		}
+		if !r.match(host, ips) {
+			continue
+		}
+		chain := make([]*parent, 0, len(r.parents))
+		var down []*parent
+		for _, p := range r.parents {
+			if p.healthy() {
+				chain = append(chain, p)
+			} else {
+				down = append(down, p)
+			}
+		}
+		return append(chain, down...)
+	}
+	return nil
+}
+
+type parentKey struct{}
+
+// parentProxy is the Transport's Proxy function. It uses the parent
+// chosen by roundTrip, if any.
+func parentProxy(req *http.Request) (*url.URL, error) {
+	if p, ok := req.Context().Value(parentKey{}).(*parent); ok {
+		return p.url, nil
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// roundTrip sends req through the parents that apply to it, moving on
+// to the next one when a parent cannot be reached.
+func (proxy *Handler) roundTrip(req *http.Request) (*http.Response, error) {
+	chain := proxy.s.parentsFor(req.URL.Hostname())
+	if len(chain) == 0 {
+		return proxy.Tr.RoundTrip(req)
+	}
+	// A request with a body can only be sent once.
+	replayable := req.Body == nil || req.Body == http.NoBody
+
+	var err error
+	for _, p := range chain {
+		var resp *http.Response
+		r := req.WithContext(context.WithValue(req.Context(), parentKey{}, p))
+		resp, err = proxy.Tr.RoundTrip(r)
+		if err == nil {
+			return resp, nil
+		}
+		var opErr *net.OpError
+		if p == direct || !errors.As(err, &opErr) || opErr.Op != "proxyconnect" {
+			return nil, err
+		}
+		p.fail(err)
+		if !replayable {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// dial connects to addr for a tunnel, through the parents that apply.
+func (proxy *Handler) dial(addr string) (net.Conn, error) {
+	start := time.Now()
+	conn, err := proxy.dialParents(addr)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	dialSeconds.WithLabelValues(result).Observe(time.Since(start).Seconds())
+	return conn, err
+}
+
+func (proxy *Handler) dialParents(addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	chain := proxy.s.parentsFor(host)
+	if len(chain) == 0 {
+		return proxy.dialDirect(addr)
+	}
+	for _, p := range chain {
+		var conn net.Conn
+		conn, err = proxy.dialVia(p, addr)
+		if err == nil {
+			return conn, nil
+		}
+		if p != direct && !errors.Is(err, errRefused) {
+			p.fail(err)
+		}
+	}
+	return nil, err
+}
+
+func (proxy *Handler) dialVia(p *parent, addr string) (net.Conn, error) {
+	if p == direct {
+		return proxy.dialDirect(addr)
+	}
+	if p.url.Scheme == "socks5" {
+		var auth *xproxy.Auth
+		if p.url.User != nil {
+			pass, _ := p.url.User.Password()
+			auth = &xproxy.Auth{User: p.url.User.Username(), Password: pass}
+		}
+		d, err := xproxy.SOCKS5("tcp", p.url.Host, auth, &proxy.d)
+		if err != nil {
+			return nil, err
+		}
+		return d.Dial("tcp", addr)
+	}
+
+	conn, err := proxy.d.Dial("tcp", p.url.Host)
+	if err != nil {
+		return nil, err
+	}
+	if p.url.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: p.url.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if p.url.User != nil {
+		pass, _ := p.url.User.Password()
+		req.Header.Set("Proxy-Authorization",
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(p.url.User.Username()+":"+pass)))
+	}
+	conn.SetDeadline(time.Now().Add(proxy.d.Timeout))
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("parent proxy %s answered %s: %w", p.name, resp.Status, errRefused)
+	}
+	conn.SetDeadline(time.Time{})
+	return &bufferedConn{conn, br}, nil
+}
@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"httpproxy/config"
+)
+
+func TestParentsFor(t *testing.T) {
+	s := &state{parentRules: compileParents(&config.Config{
+		Parents: map[string]string{
+			"a":   "http://a.example.net:3128",
+			"b":   "socks5://b.example.net:1080",
+			"bad": "ftp://c.example.net",
+		},
+		ParentRules: []config.ParentRule{
+			{Domains: []string{"Internal.corp"}, Parents: []string{"direct"}},
+			{CIDRs: []string{"10.0.0.0/8"}, Parents: []string{"b"}},
+			{Domains: []string{"example.com"}, Parents: []string{"a", "b", "direct"}},
+			{Domains: []string{"unknown.com"}, Parents: []string{"bad", "nowhere"}},
+			{Domains: []string{"*"}, Parents: []string{"b", "a"}},
+		},
+	})}
+	tests := []struct {
+		host string
+		want string // names of the parents, in order
+	}{
+		{"git.internal.corp", "direct"},
+		{"10.1.2.3", "b"},
+		{"example.com", "a b direct"},
+		{"www.EXAMPLE.com", "a b direct"},
+		// The rule without a known parent is dropped.
+		{"unknown.com", "b a"},
+		{"192.0.2.1", "b a"},
+	}
+	for _, tt := range tests {
+		var names []string
+		for _, p := range s.parentsFor(tt.host) {
+			names = append(names, p.name)
+		}
+		if got := strings.Join(names, " "); got != tt.want {
+			t.Errorf("parentsFor(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+
+	// A failed parent is tried last.
+	s.parentsFor("example.com")[0].fail(errors.New("test"))
+	var names []string
+	for _, p := range s.parentsFor("example.com") {
+		names = append(names, p.name)
+	}
+	if got := strings.Join(names, " "); got != "b direct a" {
+		t.Errorf("with a down: %q, want %q", got, "b direct a")
+	}
+
+	if got := (&state{}).parentsFor("example.com"); got != nil {
+		t.Errorf("without rules: %v", got)
+	}
+}
+
+// fakeParent is an HTTP proxy answering CONNECT with status, and
+// echoing what the tunnel carries when it is 200.
+func fakeParent(t *testing.T, status int) (addr string, auth chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	auth = make(chan string, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				req, err := http.ReadRequest(br)
+				if err != nil {
+					return
+				}
+				auth <- req.Header.Get("Proxy-Authorization")
+				fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status))
+				if status == http.StatusOK {
+					io.Copy(conn, br)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), auth
+}
+
+func TestDialVia(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		user   string
+		auth   string
+		err    error
+	}{
+		{"tunnel", http.StatusOK, "", "", nil},
+		{"credentials", http.StatusOK, "u:p@", "Basic dTpw", nil},
+		{"refused", http.StatusForbidden, "", "", errRefused},
+	}
+	for _, tt := range tests {
+		addr, auth := fakeParent(t, tt.status)
+		p := compileParents(&config.Config{
+			Parents:     map[string]string{"p": "http://" + tt.user + addr},
+			ParentRules: []config.ParentRule{{Domains: []string{"*"}, Parents: []string{"p"}}},
+		})[0].parents[0]
+		proxy := &Handler{d: net.Dialer{Timeout: 5 * time.Second}}
+		conn, err := proxy.dialVia(p, "example.com:443")
+		if got := <-auth; got != tt.auth {
+			t.Errorf("%s: Proxy-Authorization %q, want %q", tt.name, got, tt.auth)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.err)
+		}
+		if err != nil {
+			continue
+		}
+		conn.Write([]byte("ping"))
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(conn, b); err != nil || string(b) != "ping" {
+			t.Errorf("%s: read %q, %v through the tunnel", tt.name, b, err)
+		}
+		conn.Close()
+	}
+}
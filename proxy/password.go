@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"httpproxy/config"
+)
+
+// verifiedTTL is how long a successful password check is remembered.
+// Hashing on every proxied request would be far too slow.
+const verifiedTTL = 5 * time.Minute
+
+var (
+	verifiedMu sync.Mutex
+	verified   = make(map[[sha256.Size]byte]time.Time)
+	verifyKey  = make([]byte, 32)
+)
+
+func init() {
+	rand.Read(verifyKey)
+}
+
+// verifyPassword checks passwd against hash, remembering successes.
+// The hash is part of the key, so a changed password is checked again.
+func verifyPassword(hash, passwd string) bool {
+	var key [sha256.Size]byte
+	mac := hmac.New(sha256.New, verifyKey)
+	mac.Write([]byte(hash))
+	mac.Write([]byte{0})
+	mac.Write([]byte(passwd))
+	copy(key[:], mac.Sum(nil))
+
+	now := time.Now()
+	verifiedMu.Lock()
+	t, ok := verified[key]
+	verifiedMu.Unlock()
+	if ok && now.Sub(t) < verifiedTTL {
+		return true
+	}
+
+	if !config.VerifyPassword(hash, passwd) {
+		return false
+	}
+	verifiedMu.Lock()
+	for k, t := range verified {
+		if now.Sub(t) >= verifiedTTL {
+			delete(verified, k)
+		}
+	}
+	verified[key] = now
+	verifiedMu.Unlock()
+	return true
+}
@@ -6,39 +6,60 @@
 package proxy
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"httpproxy/cache"
+	"httpproxy/config"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"sync/atomic"
 	"time"
 )
 
-// Handler is the main structure
+// Handler is the main structure.
+// ServeHTTP works on a copy of it, so per-request fields are not shared.
 type Handler struct {
+	Tr *http.Transport
+	d  net.Dialer
 	// User records user's name
-	Tr   http.Transport
-	d    net.Dialer
 	User string
+	// rec is the access record of the request
+	rec *AccessRecord
+	// token is the access token the user logged in with, if any
+	token *config.Token
+	// charged counts the bytes charged to token while tunnelling
+	charged *atomic.Int64
+	// s is the state the request is served with
+	s *state
 }
 
 // NewProxyServer returns a new proxyserver.
 func NewProxyServer() *http.Server {
-	if cnfg.Cache {
+	s := current.Load()
+	if s.cnfg.Cache {
 		RegisterCacheBox(cache.NewCacheBox(":6379", ""))
 	}
+	initBlocklists(&s.cnfg)
+	initBanList()
+	initUserFile(s.cnfg.UserFile)
+	initTokens(s.cnfg.TokenFile)
 
-	return &http.Server{
-		Handler: &Handler{
-			Tr: http.Transport{Proxy: http.ProxyFromEnvironment},
-			d: net.Dialer{
-				DualStack: true,
-				Timeout:   10 * time.Second,
-				KeepAlive: 5 * time.Minute,
-			},
+	h := &Handler{
+		Tr: &http.Transport{Proxy: parentProxy},
+		d: net.Dialer{
+			DualStack: true,
+			Timeout:   10 * time.Second,
+			KeepAlive: 5 * time.Minute,
 		},
+	}
+	h.Tr.DialContext = h.dialContext
+
+	return &http.Server{
+		Handler:        h,
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		IdleTimeout:    15 * time.Minute,
@@ -48,7 +69,19 @@ func NewProxyServer() *http.Server {
 
 //ServeHTTP will be automatically called by system.
 //Server implements the Handler interface which need ServeHTTP.
-func (proxy *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	session := *h
+	proxy := &session
+	proxy.s = current.Load()
+	req = withState(req, proxy.s)
+	proxy.rec = newAccessRecord(req)
+	req = withRecord(req, proxy.rec)
+	defer proxy.logAccess()
+	rw = proxy.record(rw)
+	if req.Body != nil && req.Body != http.NoBody {
+		req.Body = &countingBody{req.Body, proxy.rec}
+	}
+
 	defer func() {
 		if err := recover(); err != nil {
 			rw.WriteHeader(http.StatusInternalServerError)
@@ -58,21 +91,52 @@ func (proxy *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}()
 
 	// log.Debug("Host := %v", req.URL.Host)
+	fromExtendedConnect(req)
+	toAbsolute(req)
+
+	release, reversed := proxy.ReverseHandler(req)
+	defer release()
 
-	if proxy.Auth(rw, req) {
+	if reversed {
+		proxy.User = "Anonymous"
+		proxy.rec.Auth = "none"
+		req = req.WithContext(context.WithValue(req.Context(), egressExempt{}, true))
+	} else if proxy.Auth(rw, req) {
 		return
 	}
 
-	proxy.ReverseHandler(req)
+	if !reversed && proxy.Schedule(rw, req) {
+		return
+	}
 
 	if proxy.Ban(rw, req) {
 		return
 	}
 
+	if !reversed && proxy.ACL(rw, req) {
+		return
+	}
+
+	if proxy.TokenLimit(rw, req) {
+		return
+	}
+
+	if !reversed && proxy.Egress(rw, req) {
+		return
+	}
+
+	done, ok := proxy.Limit(rw, req)
+	if !ok {
+		return
+	}
+	defer done()
+
 	if req.Method == "CONNECT" {
 		boost := req.Header.Get("X-Proxy-Boost") != "boosted"
 		proxy.HttpsHandler(rw, req, boost)
-	} else if cnfg.Cache == true && req.Method == "GET" {
+	} else if IsUpgrade(req) {
+		proxy.UpgradeHandler(rw, req)
+	} else if proxy.s.cnfg.Cache == true && req.Method == "GET" {
 		proxy.CacheHandler(rw, req)
 	} else {
 		proxy.HttpHandler(rw, req)
@@ -85,11 +149,13 @@ func (proxy *Handler) HttpHandler(rw http.ResponseWriter, req *http.Request) {
 	log.Infof("%s is sending request %s %s", proxy.User, req.Method, req.Host)
 	SanitizeRequest(req)
 	RmProxyHeaders(req)
+	s := proxy.shaperFor(req.URL.Host)
+	req.Body = s.body(req.Body)
 
-	resp, err := proxy.Tr.RoundTrip(req)
+	resp, err := proxy.roundTrip(req)
 	if err != nil {
 		log.Error(err)
-		http.Error(rw, err.Error(), 500)
+		proxy.upstreamError(rw, req, err)
 		return
 	}
 	defer resp.Body.Close()
@@ -99,7 +165,11 @@ func (proxy *Handler) HttpHandler(rw http.ResponseWriter, req *http.Request) {
 
 	rw.WriteHeader(resp.StatusCode) //写入响应状态
 
-	nr, err := io.Copy(rw, resp.Body)
+	if s.limited() {
+		// A throttled response may outlast the write timeout.
+		http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+	}
+	nr, err := io.Copy(s.writer(rw), resp.Body)
 	if err != nil && err != io.EOF {
 		log.Errorf("%s got an error when copy remote response to client.%v", proxy.User, err)
 		return
@@ -112,38 +182,45 @@ var HTTP_200 = []byte("HTTP/1.1 200 Connection Established\r\n\r\n")
 // HttpsHandler handles any connection which need connect method.
 // 处理https连接，主要用于CONNECT方法
 func (proxy *Handler) HttpsHandler(rw http.ResponseWriter, req *http.Request, boost200 bool) {
+	if req.ProtoMajor == 2 {
+		proxy.http2Tunnel(rw, req, boost200)
+		return
+	}
 	log.Infof("%s tried to connect to %s", proxy.User, req.URL.Host)
 
 	hj, _ := rw.(http.Hijacker)
-	client, _, err := hj.Hijack() //获取客户端与代理服务器的tcp连接
+	conn, brw, err := hj.Hijack() //获取客户端与代理服务器的tcp连接
 	if err != nil {
 		log.Errorf("%s failed to get Tcp connection of %s", proxy.User, req.RequestURI)
-		http.Error(rw, "Failed", http.StatusBadRequest)
+		proxy.errorPage(rw, req, http.StatusInternalServerError, "upstream", "")
 		return
 	}
+	// A boosted client may have sent data already.
+	client := &bufferedConn{conn, brw.Reader}
 	if boost200 {
 		// 提前发送200，减少RTT时间
 		client.Write(HTTP_200)
 	}
-	remote, err := proxy.d.Dial("tcp", req.URL.Host) //建立服务端和代理服务器的tcp连接
+	remote, err := proxy.dial(req.URL.Host) //建立服务端和代理服务器的tcp连接
 	if err != nil {
 		log.Errorf("%s failed to connect %s", proxy.User, req.RequestURI)
 		// If 200 is not sent, we can report the error to client.
 		if !boost200 {
+			code, kind, rule := upstreamStatus(err)
+			e := newErrorData(req, code, kind, proxy.User, rule)
+			body, ctype := renderErrorPage(req, e)
 			resp := &http.Response{
-				StatusCode:    502,
+				StatusCode:    code,
 				ProtoMajor:    req.ProtoMajor,
 				ProtoMinor:    req.ProtoMinor,
-				Header:        make(http.Header),
-				Body:          ioutil.NopCloser(bytes.NewBufferString(err.Error())),
-				ContentLength: int64(len(err.Error())),
+				Header:        http.Header{"Content-Type": {ctype}, "X-Request-Id": {e.RequestID}},
+				Body:          ioutil.NopCloser(bytes.NewReader(body)),
+				ContentLength: int64(len(body)),
 				Close:         true,
 				Request:       req,
 			}
-			if err.(*net.OpError).Timeout() {
-				resp.StatusCode = 504
-			}
 			resp.Write(client)
+			proxy.rec.Status = resp.StatusCode
 		}
 		client.Close()
 		return
@@ -152,21 +229,43 @@ func (proxy *Handler) HttpsHandler(rw http.ResponseWriter, req *http.Request, bo
 	if !boost200 {
 		client.Write(HTTP_200)
 	}
+	defer proxy.watchTunnel(req, remote)()
 
-	go copyRemoteToClient(proxy.User, remote, client)
-	go copyRemoteToClient(proxy.User, client, remote)
+	s := proxy.shaperFor(req.URL.Host)
+	up, down := splice(proxy.User, req.URL.Host, s.conn(proxy.metered(client)), remote)
+	proxy.rec.tunnelled(http.StatusOK, up, down)
 }
 
-func copyRemoteToClient(User string, remote, client net.Conn) {
-	defer func() {
+// bufferedConn is a hijacked connection whose reads go through the
+// bufio.Reader returned by Hijack, so no buffered data is lost.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// splice copies data between client and remote in both directions
+// until either side is done, then closes both.
+// It returns the bytes sent upstream and downstream.
+func splice(User, target string, client, remote io.ReadWriteCloser) (up, down int64) {
+	activeTunnels.Inc()
+	defer activeTunnels.Dec()
+
+	done := make(chan struct{})
+	go func() {
+		up, _ = io.Copy(remote, client)
 		remote.Close()
 		client.Close()
+		close(done)
 	}()
+	down, _ = io.Copy(client, remote)
+	client.Close()
+	remote.Close()
+	<-done
 
-	nr, err := io.Copy(remote, client)
-	if err != nil && err != io.EOF {
-		//log.Error("%v got an error when handles CONNECT %v\n", User, err)
-		return
-	}
-	log.Info("%v transported %v bytes betwwen %v and %v.\n", User, nr, remote.RemoteAddr(), client.RemoteAddr())
+	log.Infof("%v transported %d bytes to and %d bytes from %v", User, up, down, target)
+	return up, down
 }
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net"
+	"sync"
+
+	"httpproxy/cache"
+	"httpproxy/config"
+)
+
+var (
+	reloadMu    sync.Mutex
+	reloadHooks []func(proxyLn, webLn net.Listener)
+)
+
+// OnReload registers f to run after each successful reload. It gets the
+// new proxy and web listeners, or nil when an address did not change;
+// f takes them over and should close the old ones.
+func OnReload(f func(proxyLn, webLn net.Listener)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadHooks = append(reloadHooks, f)
+}
+
+// Reload re-reads the config file, checks it and swaps it in. New
+// requests use the new config, tunnels already open keep running.
+// On error the old config is kept.
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	old := &current.Load().cnfg
+	next, err := config.Load(old.Path())
+	if err != nil {
+		log.Errorf("reload: %v", err)
+		return err
+	}
+
+	// Open changed listeners first, so a bad address keeps the old config.
+	var pln, wln net.Listener
+	if next.Listen != old.Listen {
+		if pln, err = ProxyListener(next.Listen); err != nil {
+			log.Errorf("reload: %v", err)
+			return err
+		}
+	}
+	if next.WebListen != old.WebListen {
+		if wln, err = WebListener(next.WebListen); err != nil {
+			if pln != nil {
+				pln.Close()
+			}
+			log.Errorf("reload: %v", err)
+			return err
+		}
+	}
+
+	publish(*next)
+	setLog(next)
+	if next.Cache && cacheBox == nil {
+		RegisterCacheBox(cache.NewCacheBox(":6379", ""))
+	}
+	initBlocklists(next)
+	initBanList()
+	initUserFile(next.UserFile)
+	initTokens(next.TokenFile)
+	RefreshBandwidth()
+	loadErrorPages()
+	// Passwords hashed by Load are only saved once the config is in use.
+	if err := next.SaveRehashed(); err != nil {
+		log.Errorf("reload: %v", err)
+	}
+
+	for _, f := range reloadHooks {
+		f(pln, wln)
+	}
+	log.Infof("reloaded %s", next.Path())
+	return nil
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"httpproxy/config"
+)
+
+// useConfigFile writes body to a config file and makes it the current
+// config for the rest of the test.
+func useConfigFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useConfig(t, *c)
+	return path
+}
+
+func TestReload(t *testing.T) {
+	const pool = `"upstreams":{"app":{"servers":[{"addr":"127.0.0.1:8001"}]}}`
+	tests := []struct {
+		name          string
+		body          string
+		err           bool
+		gfwlist       string // the first gfwlist rule afterwards
+		sameUpstreams bool
+	}{
+		{"changed rule", `{"gfwlist":["b.com"],` + pool + `}`, false, "b.com", true},
+		{"changed upstreams", `{"gfwlist":["b.com"],"upstreams":{"app":{"servers":[{"addr":"127.0.0.1:8002"}]}}}`, false, "b.com", false},
+		{"bad json", `{"gfwlist":`, true, "a.com", true},
+		{"fails the check", `{"gfwlist":["b.com"],"acl":{"default":"maybe"}}`, true, "a.com", true},
+	}
+	for _, tt := range tests {
+		path := useConfigFile(t, `{"gfwlist":["a.com"],`+pool+`}`)
+		before := current.Load()
+		if err := os.WriteFile(path, []byte(tt.body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		err := Reload()
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.err)
+		}
+		after := current.Load()
+		if tt.err != (after == before) {
+			t.Errorf("%s: state replaced %v", tt.name, after != before)
+		}
+		if got := after.cnfg.GFWList[0]; got != tt.gfwlist {
+			t.Errorf("%s: gfwlist %q, want %q", tt.name, got, tt.gfwlist)
+		}
+		if got := after.upstreams["app"] == before.upstreams["app"]; got != tt.sameUpstreams {
+			t.Errorf("%s: upstreams kept %v, want %v", tt.name, got, tt.sameUpstreams)
+		}
+		// A request which loaded the old state keeps seeing it whole.
+		if before.cnfg.GFWList[0] != "a.com" {
+			t.Errorf("%s: the old state changed", tt.name)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	errChange := errors.New("no")
+	tests := []struct {
+		name   string
+		change func(c *config.Config) error
+		err    bool
+		want   string
+	}{
+		{"applied", func(c *config.Config) error { c.GFWList = []string{"b.com"}; return nil }, false, "b.com"},
+		{"refused", func(c *config.Config) error { c.GFWList = []string{"b.com"}; return errChange }, true, "a.com"},
+		{"fails the check", func(c *config.Config) error {
+			c.GFWList = []string{"b.com"}
+			c.ACL.Default = "maybe"
+			return nil
+		}, true, "a.com"},
+	}
+	for _, tt := range tests {
+		path := useConfigFile(t, `{"gfwlist":["a.com"]}`)
+		before := current.Load()
+		err := update(tt.change)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err = %v, want error %v", tt.name, err, tt.err)
+		}
+		if got := current.Load().cnfg.GFWList[0]; got != tt.want {
+			t.Errorf("%s: gfwlist %q, want %q", tt.name, got, tt.want)
+		}
+		if before.cnfg.GFWList[0] != "a.com" {
+			t.Errorf("%s: the old state changed", tt.name)
+		}
+		saved, err := config.Load(path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if saved.GFWList[0] != tt.want {
+			t.Errorf("%s: saved gfwlist %q, want %q", tt.name, saved.GFWList[0], tt.want)
+		}
+		req := httptest.NewRequest("GET", "http://b.com/", nil)
+		if r := bannedBy(destinationOf(req), "http://b.com/"); (r != nil) != (tt.want == "b.com") {
+			t.Errorf("%s: ban list not rebuilt", tt.name)
+		}
+	}
+}
@@ -5,18 +5,27 @@ import (
 )
 
 //ReverseHandler handles request for reverse proxy.
+//It reports whether req was rewritten to a backend, either by a route
+//or by the reverse option. The returned function must be called once the
+//request is done.
 //处理反向代理请求
-func (proxy *Handler) ReverseHandler(req *http.Request) {
-	if cnfg.Reverse == true { //用于反向代理
-		proxy.reverseHandler(req)
+func (proxy *Handler) ReverseHandler(req *http.Request) (func(), bool) {
+	if r := proxy.s.matchRoute(req); r != nil {
+		return proxy.routeHandler(req, r), true
 	}
+	if proxy.s.cnfg.Reverse == true { //用于反向代理
+		return proxy.reverseHandler(req), true
+	}
+	return func() {}, false
 }
 
 //ReverseHandler handles request for reverse proxy.
 //处理反向代理请求
-func (proxy *Handler) reverseHandler(req *http.Request) {
-	req.Host = cnfg.ProxyPass
+func (proxy *Handler) reverseHandler(req *http.Request) func() {
+	addr, release := proxy.s.upstreamAddr(proxy.s.cnfg.ProxyPass, req)
+	req.Host = addr
 	req.URL.Host = req.Host
 	req.URL.Scheme = "http"
 	log.Debug("%v", req.RequestURI)
+	return release
 }
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"httpproxy/config"
+)
+
+type route struct {
+	config.Route
+	regex *regexp.Regexp
+}
+
+// compileRoutes compiles the route table of c in order. Routes with a
+// bad regexp are skipped.
+func compileRoutes(c *config.Config) []*route {
+	routes := make([]*route, 0, len(c.Routes))
+	for i, r := range c.Routes {
+		rt := &route{Route: r}
+		if r.PathRegex != "" {
+			re, err := regexp.Compile(r.PathRegex)
+			if err != nil {
+				log.Errorf("route %d: bad path_regex %q: %v", i, r.PathRegex, err)
+				continue
+			}
+			rt.regex = re
+		}
+		routes = append(routes, rt)
+	}
+	return routes
+}
+
+// matchRoute returns the first route matching req.
+// A route for a host matches any request for it, whether it was sent to
+// the proxy or, like every HTTP/2 request, names its host only in the
+// Host header. A route for any host only matches origin-form HTTP/1.1
+// requests, so proxy requests for other hosts still reach the forward
+// proxy.
+func (s *state) matchRoute(req *http.Request) *route {
+	if req.Method == "CONNECT" {
+		return nil
+	}
+	// toAbsolute has filled in the host of HTTP/2 requests.
+	toProxy := req.URL.Host == ""
+	for _, r := range s.routes {
+		if (r.Host == "" || r.Host == "*") && !toProxy {
+			continue
+		}
+		if r.match(req) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (r *route) match(req *http.Request) bool {
+	if r.Host != "" && !matchHost(r.Host, req.Host) {
+		return false
+	}
+	if r.Path != "" && !strings.HasPrefix(req.URL.Path, r.Path) {
+		return false
+	}
+	if r.regex != nil && !r.regex.MatchString(req.URL.Path) {
+		return false
+	}
+	if len(r.Methods) > 0 {
+		for _, m := range r.Methods {
+			if strings.EqualFold(m, req.Method) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// matchHost matches host against pattern, ignoring the port and case.
+// "*" matches any host and "*.example.com" matches its subdomains.
+func matchHost(pattern, host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	pattern = strings.ToLower(pattern)
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+// rewrite applies the route's prefix stripping or rewriting to the path.
+func (r *route) rewrite(req *http.Request) {
+	path := req.URL.Path
+	switch {
+	case r.regex != nil && r.RewritePrefix != "":
+		path = r.regex.ReplaceAllString(path, r.RewritePrefix)
+	case r.Path != "" && (r.StripPrefix || r.RewritePrefix != ""):
+		path = r.RewritePrefix + strings.TrimPrefix(path, r.Path)
+	default:
+		return
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	req.URL.Path = path
+	req.URL.RawPath = ""
+}
+
+// routeHandler sends req to the backend of the matched route.
+// The Host header is kept so the backend can do its own virtual hosting.
+func (proxy *Handler) routeHandler(req *http.Request, r *route) func() {
+	log.Debugf("%s %s%s matched route to %s", req.Method, req.Host, req.URL.Path, r.Pass)
+	r.rewrite(req)
+	addr, release := proxy.s.upstreamAddr(r.Pass, req)
+	req.URL.Host = addr
+	req.URL.Scheme = "http"
+	return release
+}
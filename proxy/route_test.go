@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"httpproxy/config"
+)
+
+func TestMatchRoute(t *testing.T) {
+	s := &state{routes: compileRoutes(&config.Config{Routes: []config.Route{
+		{Host: "app.example.com", Path: "/api", Pass: "api"},
+		{Host: "*.example.com", Pass: "wild"},
+		{Path: "/static", Pass: "static"},
+		{PathRegex: `^/v[0-9]+/`, Methods: []string{"GET"}, Pass: "versioned"},
+		{Host: "*", Path: "/any", Pass: "any"},
+	}})}
+	tests := []struct {
+		name   string
+		proto  int
+		method string
+		target string // as in the request line
+		host   string
+		pass   string // "" for no route
+	}{
+		// Origin-form HTTP/1.1 requests are sent to the proxy itself.
+		{"host and path", 1, "GET", "/api/x", "app.example.com", "api"},
+		{"host with port", 1, "GET", "/api/x", "APP.example.com:8080", "api"},
+		{"wildcard host", 1, "GET", "/", "www.example.com", "wild"},
+		{"path only", 1, "GET", "/static/a.css", "proxy.local", "static"},
+		{"regex and method", 1, "GET", "/v2/users", "proxy.local", "versioned"},
+		{"regex, other method", 1, "POST", "/v2/users", "proxy.local", ""},
+		{"any host", 1, "GET", "/any/x", "proxy.local", "any"},
+		{"nothing", 1, "GET", "/other", "proxy.local", ""},
+		// Proxy requests only match routes for their host.
+		{"absolute, host", 1, "GET", "http://app.example.com/api/x", "", "api"},
+		{"absolute, wildcard", 1, "GET", "http://www.example.com/static/a.css", "", "wild"},
+		{"absolute, path only", 1, "GET", "http://other.com/static/a.css", "", ""},
+		{"absolute, any host", 1, "GET", "http://other.com/any/x", "", ""},
+		{"connect", 1, "CONNECT", "app.example.com:443", "app.example.com:443", ""},
+		// HTTP/2 requests name their host in :authority only.
+		{"h2, host", 2, "GET", "/api/x", "app.example.com", "api"},
+		{"h2, path only", 2, "GET", "/static/a.css", "other.com", ""},
+		{"h2, any host", 2, "GET", "/any/x", "other.com", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		req.ProtoMajor = tt.proto
+		if tt.host != "" {
+			req.Host = tt.host
+		}
+		if tt.method == "CONNECT" {
+			req.URL.Host = tt.host
+		}
+		toAbsolute(req)
+		pass := ""
+		if r := s.matchRoute(req); r != nil {
+			pass = r.Pass
+		}
+		if pass != tt.pass {
+			t.Errorf("%s: %s %s (Host %s) matched %q, want %q", tt.name, tt.method, tt.target, req.Host, pass, tt.pass)
+		}
+	}
+}
+
+func TestRouteRewrite(t *testing.T) {
+	tests := []struct {
+		route config.Route
+		path  string
+		want  string
+	}{
+		{config.Route{Path: "/app", StripPrefix: true}, "/app/x", "/x"},
+		{config.Route{Path: "/app", StripPrefix: true}, "/app", "/"},
+		{config.Route{Path: "/app", RewritePrefix: "/v2"}, "/app/x", "/v2/x"},
+		{config.Route{Path: "/app"}, "/app/x", "/app/x"},
+		{config.Route{PathRegex: `^/u/([^/]+)`, RewritePrefix: "/users/$1"}, "/u/bob/x", "/users/bob/x"},
+	}
+	for _, tt := range tests {
+		r := compileRoutes(&config.Config{Routes: []config.Route{tt.route}})[0]
+		req := httptest.NewRequest("GET", tt.path, nil)
+		r.rewrite(req)
+		if req.URL.Path != tt.want {
+			t.Errorf("%+v on %s: got %s, want %s", tt.route, tt.path, req.URL.Path, tt.want)
+		}
+	}
+}
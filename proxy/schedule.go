@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"httpproxy/config"
+)
+
+// compileSchedules parses the profile schedules of c. Bad ones are
+// logged and skipped.
+func compileSchedules(c *config.Config) map[string]*config.Schedule {
+	schedules := make(map[string]*config.Schedule)
+	for user, p := range c.Profiles {
+		if p.Schedule == "" {
+			continue
+		}
+		s, err := config.ParseSchedule(p.Schedule)
+		if err != nil {
+			log.Errorf("profile %s: %v", user, err)
+			continue
+		}
+		schedules[user] = s
+	}
+	return schedules
+}
+
+// scheduleOf returns the schedule of user, or nil when it is not limited.
+// Like config.ProfileOf, a user without a schedule of their own has the
+// "*" one.
+func (s *state) scheduleOf(user string) *config.Schedule {
+	if sch, ok := s.userSchedules[user]; ok {
+		return sch
+	}
+	return s.userSchedules["*"]
+}
+
+// Schedule refuses users outside their schedule with a 403, and returns
+// true then.
+func (proxy *Handler) Schedule(rw http.ResponseWriter, req *http.Request) bool {
+	s := proxy.s.scheduleOf(proxy.User)
+	if s == nil || s.Active(time.Now()) {
+		return false
+	}
+	log.Infof("%s is outside of schedule %s", proxy.User, s)
+	proxy.errorPage(rw, req, http.StatusForbidden, "blocked", "schedule: "+s.String())
+	return true
+}
+
+// tunnel is an open CONNECT tunnel that is closed when its user or
+// destination leaves their schedule.
+type tunnel struct {
+	user, target string
+	allowed      func() bool
+	remote       io.Closer
+}
+
+var (
+	tunnelsMu   sync.Mutex
+	openTunnels = make(map[*tunnel]bool)
+)
+
+func init() {
+	go func() {
+		for range time.Tick(15 * time.Second) {
+			if current.Load().cnfg.ScheduleClose {
+				closeTunnels()
+			}
+		}
+	}()
+}
+
+// closeTunnels closes the tunnels that are no longer allowed.
+func closeTunnels() {
+	tunnelsMu.Lock()
+	list := make([]*tunnel, 0, len(openTunnels))
+	for t := range openTunnels {
+		list = append(list, t)
+	}
+	tunnelsMu.Unlock()
+
+	for _, t := range list {
+		if !t.allowed() {
+			log.Infof("closing tunnel of %s to %s, its schedule is over", t.user, t.target)
+			t.remote.Close()
+		}
+	}
+}
+
+// watchTunnel registers the tunnel to req over remote, to be rechecked
+// against the user's schedule, the ACL and the ban list, as they are at
+// the time of the check. The returned function must be called once the
+// tunnel is done.
+func (proxy *Handler) watchTunnel(req *http.Request, remote io.Closer) func() {
+	user := proxy.User
+	d := destinationOf(req)
+	url := urlOf(req, d)
+	t := &tunnel{
+		user:   user,
+		target: req.URL.Host,
+		remote: remote,
+		allowed: func() bool {
+			s := current.Load()
+			if sch := s.scheduleOf(user); sch != nil && !sch.Active(time.Now()) {
+				return false
+			}
+			if allow, _ := s.aclDecision(user, d); !allow {
+				return false
+			}
+			return bannedBy(d, url) == nil
+		},
+	}
+	tunnelsMu.Lock()
+	openTunnels[t] = true
+	tunnelsMu.Unlock()
+	return func() {
+		tunnelsMu.Lock()
+		delete(openTunnels, t)
+		tunnelsMu.Unlock()
+	}
+}
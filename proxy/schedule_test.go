@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"testing"
+
+	"httpproxy/config"
+)
+
+func TestScheduleOf(t *testing.T) {
+	s := newState(config.Config{Profiles: map[string]config.Profile{
+		"*":     {Schedule: "mon-fri 09:00-18:00 UTC"},
+		"guest": {Schedule: "sat UTC"},
+		// A user whose bandwidth was set from the web admin
+		"fast": {Bandwidth: config.Bandwidth{Download: 1000}},
+	}}, nil)
+	tests := []struct {
+		user string
+		want string
+	}{
+		{"nobody", "mon-fri 09:00-18:00 UTC"},
+		{"guest", "sat UTC"},
+		{"fast", "mon-fri 09:00-18:00 UTC"},
+	}
+	for _, tt := range tests {
+		got := ""
+		if sch := s.scheduleOf(tt.user); sch != nil {
+			got = sch.String()
+		}
+		if got != tt.want {
+			t.Errorf("scheduleOf(%q) = %q, want %q", tt.user, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"httpproxy/config"
+)
+
+// bucket is a token bucket holding up to one second of traffic.
+type bucket struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second, 0 means unlimited
+	tokens float64
+	last   time.Time
+}
+
+func (b *bucket) setRate(rate int64) {
+	b.mu.Lock()
+	b.rate = float64(rate)
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.mu.Unlock()
+}
+
+func (b *bucket) limited() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rate > 0
+}
+
+// wait takes n tokens, sleeping until the bucket has paid them back.
+func (b *bucket) wait(n int) {
+	b.mu.Lock()
+	if b.rate <= 0 {
+		b.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	var d time.Duration
+	if b.tokens < 0 {
+		d = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+	time.Sleep(d)
+}
+
+// buckets are shared by every transfer with the same key, e.g.
+// "user:alice:up", so a limit holds across connections.
+var (
+	bucketsMu sync.Mutex
+	buckets   = make(map[string]*bucket)
+)
+
+// bandwidthFor returns the rate of a bucket key in c. Buckets are
+// shared by transfers which may have started under older configs, so
+// they always follow the current one.
+func bandwidthFor(c *config.Config, key string) int64 {
+	i := strings.LastIndex(key, ":")
+	scope, dir := key[:i], key[i+1:]
+
+	var bw config.Bandwidth
+	switch {
+	case scope == "global":
+		bw = c.Bandwidth
+	case strings.HasPrefix(scope, "user:"):
+		bw = c.ProfileOf(scope[len("user:"):]).Bandwidth
+	case strings.HasPrefix(scope, "domain:"):
+		bw = c.DomainBandwidth[scope[len("domain:"):]]
+	}
+	if dir == "up" {
+		return bw.Upload
+	}
+	return bw.Download
+}
+
+func bucketFor(key string) *bucket {
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+	b, ok := buckets[key]
+	if !ok {
+		b = &bucket{last: time.Now()}
+		buckets[key] = b
+	}
+	b.setRate(bandwidthFor(&current.Load().cnfg, key))
+	return b
+}
+
+// RefreshBandwidth applies changed limits to the transfers in progress.
+func RefreshBandwidth() {
+	c := &current.Load().cnfg
+	bucketsMu.Lock()
+	defer bucketsMu.Unlock()
+	for key, b := range buckets {
+		b.setRate(bandwidthFor(c, key))
+	}
+}
+
+// domainOf returns the longest entry of c.DomainBandwidth that host
+// falls under.
+func domainOf(c *config.Config, host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	best := ""
+	for domain := range c.DomainBandwidth {
+		d := strings.ToLower(domain)
+		if (host == d || strings.HasSuffix(host, "."+d)) && len(d) > len(best) {
+			best = domain
+		}
+	}
+	return best
+}
+
+// shaper throttles a transfer with every bucket that applies to it.
+type shaper struct {
+	up, down []*bucket
+}
+
+// shaperFor returns the shaper for the current user sending to host.
+// It holds every bucket of its scopes, limited or not, so a limit set
+// later applies to transfers already running.
+func (proxy *Handler) shaperFor(host string) *shaper {
+	scopes := []string{"global", "user:" + proxy.User}
+	if domain := domainOf(&proxy.s.cnfg, host); domain != "" {
+		scopes = append(scopes, "domain:"+domain)
+	}
+	s := &shaper{}
+	for _, scope := range scopes {
+		s.up = append(s.up, bucketFor(scope+":up"))
+		s.down = append(s.down, bucketFor(scope+":down"))
+	}
+	return s
+}
+
+// limited reports whether any bucket of s is limited now.
+func (s *shaper) limited() bool {
+	for _, b := range append(s.up, s.down...) {
+		if b.limited() {
+			return true
+		}
+	}
+	return false
+}
+
+func waitAll(bs []*bucket, n int) {
+	for _, b := range bs {
+		b.wait(n)
+	}
+}
+
+// shapedConn limits a client connection: reads are uploads and writes
+// are downloads.
+type shapedConn struct {
+	io.ReadWriteCloser
+	s *shaper
+}
+
+func (c *shapedConn) Read(b []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(b)
+	waitAll(c.s.up, n)
+	return n, err
+}
+
+func (c *shapedConn) Write(b []byte) (int, error) {
+	waitAll(c.s.down, len(b))
+	return c.ReadWriteCloser.Write(b)
+}
+
+// conn wraps the client side of a tunnel.
+func (s *shaper) conn(c io.ReadWriteCloser) io.ReadWriteCloser {
+	return &shapedConn{c, s}
+}
+
+type shapedWriter struct {
+	io.Writer
+	s *shaper
+}
+
+func (w *shapedWriter) Write(b []byte) (int, error) {
+	waitAll(w.s.down, len(b))
+	return w.Writer.Write(b)
+}
+
+// writer wraps the writer a response is copied to the client with.
+func (s *shaper) writer(w io.Writer) io.Writer {
+	return &shapedWriter{w, s}
+}
+
+type shapedBody struct {
+	io.ReadCloser
+	s *shaper
+}
+
+func (r *shapedBody) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	waitAll(r.s.up, n)
+	return n, err
+}
+
+// body wraps the body of a request sent by the client.
+func (s *shaper) body(r io.ReadCloser) io.ReadCloser {
+	// Leave NoBody alone, the transport relies on it.
+	if r == nil || r == http.NoBody {
+		return r
+	}
+	return &shapedBody{r, s}
+}
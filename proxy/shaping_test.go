@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"httpproxy/config"
+)
+
+func TestBandwidthFor(t *testing.T) {
+	c := &config.Config{
+		Bandwidth: config.Bandwidth{Upload: 100, Download: 200},
+		Profiles: map[string]config.Profile{
+			"*":     {Bandwidth: config.Bandwidth{Upload: 10, Download: 20}},
+			"alice": {Bandwidth: config.Bandwidth{Upload: 1, Download: 2}},
+		},
+		DomainBandwidth: map[string]config.Bandwidth{"example.com": {Upload: 5, Download: 6}},
+	}
+	tests := []struct {
+		key  string
+		want int64
+	}{
+		{"global:up", 100},
+		{"global:down", 200},
+		{"user:alice:up", 1},
+		{"user:alice:down", 2},
+		{"user:bob:up", 10},
+		{"user:bob:down", 20},
+		{"domain:example.com:up", 5},
+		{"domain:example.com:down", 6},
+		{"domain:other.com:down", 0},
+	}
+	for _, tt := range tests {
+		if got := bandwidthFor(c, tt.key); got != tt.want {
+			t.Errorf("bandwidthFor(%q) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestDomainOf(t *testing.T) {
+	c := &config.Config{DomainBandwidth: map[string]config.Bandwidth{
+		"example.com":     {},
+		"cdn.example.com": {},
+		"Video.org":       {},
+	}}
+	tests := []struct {
+		host, want string
+	}{
+		{"example.com", "example.com"},
+		{"www.example.com:443", "example.com"},
+		{"a.cdn.example.com", "cdn.example.com"},
+		{"EXAMPLE.com.", "example.com"},
+		{"video.org", "Video.org"},
+		{"notexample.com", ""},
+		{"192.0.2.1:80", ""},
+	}
+	for _, tt := range tests {
+		if got := domainOf(c, tt.host); got != tt.want {
+			t.Errorf("domainOf(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestBucketWait(t *testing.T) {
+	tests := []struct {
+		rate     int64
+		n        []int // sizes taken in turn
+		min, max time.Duration
+	}{
+		{0, []int{1 << 20}, 0, 50 * time.Millisecond},
+		// A new bucket is empty, the rate is paid from the start.
+		{100000, []int{10000}, 80 * time.Millisecond, 300 * time.Millisecond},
+		{100000, []int{5000, 5000, 5000}, 130 * time.Millisecond, 400 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		b := &bucket{last: time.Now()}
+		b.setRate(tt.rate)
+		start := time.Now()
+		for _, n := range tt.n {
+			b.wait(n)
+		}
+		if d := time.Since(start); d < tt.min || d > tt.max {
+			t.Errorf("rate %d, taking %v: took %v, want %v to %v", tt.rate, tt.n, d, tt.min, tt.max)
+		}
+	}
+}
+
+func TestShapedWriter(t *testing.T) {
+	limited := &bucket{last: time.Now()}
+	limited.setRate(100000)
+	s := &shaper{down: []*bucket{{last: time.Now()}, limited}}
+	if !s.limited() {
+		t.Error("shaper with a limited bucket is not limited")
+	}
+	var out bytes.Buffer
+	start := time.Now()
+	if n, err := s.writer(&out).Write(make([]byte, 10000)); n != 10000 || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Errorf("10000 bytes at 100000/s took %v", d)
+	}
+	if (&shaper{down: []*bucket{{}}}).limited() {
+		t.Error("shaper without limits is limited")
+	}
+}
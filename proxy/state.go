@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+
+	"httpproxy/config"
+	"httpproxy/lib"
+)
+
+// state is the config and everything compiled from it. A published
+// state is never changed: reloads and the web admin build a new one and
+// swap it in. A request loads it once, so it never sees half of a change.
+type state struct {
+	cnfg          config.Config
+	upstreams     map[string]*upstreamPool
+	routes        []*route
+	aclRules      []*aclRule
+	parentRules   []*parentRule
+	egress        *egressPolicy
+	userSchedules map[string]*config.Schedule
+	authenticator lib.Authenticator
+	accessLog     *accessLogger
+}
+
+var current atomic.Pointer[state]
+
+func init() {
+	s := &state{}
+	s.authenticator = localAuthenticator{s}
+	current.Store(s)
+}
+
+// newState compiles c. The upstream pools and the access log of old are
+// kept when their config did not change, as they hold health checks and
+// an open file.
+func newState(c config.Config, old *state) *state {
+	s := &state{cnfg: c}
+	if keepsUpstreams(old, &c) {
+		s.upstreams = old.upstreams
+	} else {
+		s.upstreams = newUpstreams(&s.cnfg)
+	}
+	if old != nil && old.accessLog != nil && old.cnfg.AccessLog == c.AccessLog {
+		s.accessLog = old.accessLog
+	} else {
+		s.accessLog = openAccessLog(s.cnfg.AccessLog)
+	}
+	s.routes = compileRoutes(&s.cnfg)
+	s.aclRules = compileACL(&s.cnfg)
+	s.parentRules = compileParents(&s.cnfg)
+	s.egress = compileEgress(&s.cnfg)
+	s.userSchedules = compileSchedules(&s.cnfg)
+	s.authenticator = newAuthenticator(s)
+	return s
+}
+
+// keepsUpstreams tells whether the pools of old can serve c.
+func keepsUpstreams(old *state, c *config.Config) bool {
+	return old != nil && old.upstreams != nil && reflect.DeepEqual(old.cnfg.Upstreams, c.Upstreams)
+}
+
+// publish swaps in the state compiled from c and retires what the old
+// one no longer shares. reloadMu must be held.
+func publish(c config.Config) *state {
+	old := current.Load()
+	s := newState(c, old)
+	current.Store(s)
+	if !keepsUpstreams(old, &c) {
+		stopUpstreams(old.upstreams)
+	}
+	if old.accessLog != nil && old.accessLog != s.accessLog {
+		old.accessLog.close()
+	}
+	return s
+}
+
+// update applies change to a copy of the config, checks the result,
+// swaps it in and saves it. On error nothing changes.
+func update(change func(c *config.Config) error) error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	c, err := current.Load().cnfg.Clone()
+	if err != nil {
+		return err
+	}
+	if err := change(c); err != nil {
+		return err
+	}
+	if err := c.Check(); err != nil {
+		return err
+	}
+	publish(*c)
+	initBanList()
+	RefreshBandwidth()
+	return c.WriteToFile()
+}
+
+// stateKey is the context key of the state a request loaded, for the
+// Transport's callbacks which only get the request or its context.
+type stateKey struct{}
+
+func withState(req *http.Request, s *state) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), stateKey{}, s))
+}
+
+// stateOf returns the state ctx was served with, or the current one.
+func stateOf(ctx context.Context) *state {
+	if s, ok := ctx.Value(stateKey{}).(*state); ok {
+		return s
+	}
+	return current.Load()
+}
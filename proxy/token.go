@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"httpproxy/config"
+)
+
+// tokenPrefix marks proxy tokens, so a Basic password can be told apart.
+const tokenPrefix = "tk_"
+
+var (
+	tokensMu    sync.Mutex
+	tokens      = make(map[string]*config.Token) // by hash
+	tokensPath  string
+	tokensDirty bool
+)
+
+func init() {
+	// Usage changes often, so it is written out at most once a minute.
+	go func() {
+		for range time.Tick(time.Minute) {
+			tokensMu.Lock()
+			if tokensDirty {
+				saveTokens()
+			}
+			tokensMu.Unlock()
+		}
+	}()
+}
+
+// initTokens loads the token file path. The tokens in memory are kept
+// when the file did not change, as they have the latest usage.
+func initTokens(path string) {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+	if path == tokensPath {
+		return
+	}
+	if tokensDirty {
+		saveTokens()
+	}
+	tokensPath = path
+	tokens = make(map[string]*config.Token)
+	if tokensPath == "" {
+		return
+	}
+
+	b, err := os.ReadFile(tokensPath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Errorf("token file: %v", err)
+		return
+	}
+	var list []*config.Token
+	if err := json.Unmarshal(b, &list); err != nil {
+		log.Errorf("token file %s: %v", tokensPath, err)
+		return
+	}
+	for _, t := range list {
+		if !t.Expired() {
+			tokens[t.Hash] = t
+		}
+	}
+}
+
+// saveTokens writes the tokens out. tokensMu must be held.
+func saveTokens() {
+	if tokensPath == "" {
+		return
+	}
+	list := make([]*config.Token, 0, len(tokens))
+	for _, t := range tokens {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	b, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	tmp := tokensPath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		log.Errorf("token file: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, tokensPath); err != nil {
+		log.Errorf("token file: %v", err)
+		return
+	}
+	tokensDirty = false
+}
+
+// FlushTokens writes out unsaved token usage, e.g. before exiting.
+func FlushTokens() {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+	if tokensDirty {
+		saveTokens()
+	}
+}
+
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueToken creates a token for user and returns its secret, which is
+// not stored anywhere.
+func IssueToken(user, note string, ttl time.Duration, domains []string, quota int64) (string, error) {
+	tokensMu.Lock()
+	configured := tokensPath != ""
+	tokensMu.Unlock()
+	if !configured {
+		return "", errors.New("token_file is not set")
+	}
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	t := &config.Token{
+		Hash:    hashToken(secret),
+		User:    user,
+		Note:    note,
+		Created: time.Now(),
+		Domains: domains,
+		Quota:   quota,
+	}
+	t.ID = t.Hash[:12]
+	if ttl > 0 {
+		t.Expires = t.Created.Add(ttl)
+	}
+
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+	tokens[t.Hash] = t
+	saveTokens()
+	log.Infof("issued token %s for %s", t.ID, user)
+	return secret, nil
+}
+
+// revokeTokensOf deletes the tokens of user, e.g. when it is deleted.
+func revokeTokensOf(user string) {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+	for hash, t := range tokens {
+		if t.User == user {
+			delete(tokens, hash)
+			log.Infof("revoked token %s of %s", t.ID, t.User)
+		}
+	}
+	saveTokens()
+}
+
+// RevokeToken deletes the token with id.
+func RevokeToken(id string) {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+	for hash, t := range tokens {
+		if t.ID == id {
+			delete(tokens, hash)
+			log.Infof("revoked token %s of %s", id, t.User)
+		}
+	}
+	saveTokens()
+}
+
+// Tokens returns a copy of the tokens, oldest first.
+func Tokens() []config.Token {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+	list := make([]config.Token, 0, len(tokens))
+	for _, t := range tokens {
+		list = append(list, *t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+// lookupToken returns the usable token with secret, or nil. Tokens of
+// users which no longer exist are not usable.
+func (s *state) lookupToken(secret string) *config.Token {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return nil
+	}
+	tokensMu.Lock()
+	t, ok := tokens[hashToken(secret)]
+	tokensMu.Unlock()
+	if !ok || t.Expired() {
+		return nil
+	}
+	if _, ok := s.lookupUser(t.User); !ok {
+		return nil
+	}
+	return t
+}
+
+// addTokenUsage accounts bytes transferred with t. It reports whether
+// t is over its quota now.
+func addTokenUsage(t *config.Token, n int64) bool {
+	tokensMu.Lock()
+	defer tokensMu.Unlock()
+	if n > 0 {
+		t.Used += n
+		tokensDirty = true
+	}
+	return t.Quota > 0 && t.Used >= t.Quota
+}
+
+// errQuota ends a tunnel whose token used up its quota.
+var errQuota = errors.New("token quota used up")
+
+// tokenConn charges the token of a tunnel as data flows, and closes the
+// tunnel once the quota is used up.
+type tokenConn struct {
+	io.ReadWriteCloser
+	t       *config.Token
+	charged *atomic.Int64
+}
+
+func (c *tokenConn) charge(n int) error {
+	c.charged.Add(int64(n))
+	if addTokenUsage(c.t, int64(n)) {
+		c.Close()
+		return errQuota
+	}
+	return nil
+}
+
+func (c *tokenConn) Read(b []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(b)
+	if err == nil {
+		err = c.charge(n)
+	}
+	return n, err
+}
+
+func (c *tokenConn) Write(b []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(b)
+	if err == nil {
+		err = c.charge(n)
+	}
+	return n, err
+}
+
+// metered wraps the client side of a tunnel, when the request uses a
+// token with a quota.
+func (proxy *Handler) metered(c io.ReadWriteCloser) io.ReadWriteCloser {
+	if proxy.token == nil || proxy.token.Quota == 0 {
+		return c
+	}
+	if proxy.charged == nil {
+		proxy.charged = new(atomic.Int64)
+	}
+	return &tokenConn{c, proxy.token, proxy.charged}
+}
+
+// TokenLimit enforces the limits of the token the request was
+// authenticated with. It answers 403 and returns true when one is hit.
+func (proxy *Handler) TokenLimit(rw http.ResponseWriter, req *http.Request) bool {
+	t := proxy.token
+	if t == nil {
+		return false
+	}
+	if addTokenUsage(t, 0) {
+		log.Infof("%s: token %s is over its quota", proxy.User, t.ID)
+		proxy.errorPage(rw, req, http.StatusForbidden, "quota", "token: "+t.ID)
+		return true
+	}
+	if len(t.Domains) == 0 {
+		return false
+	}
+	host := destinationOf(req).host
+	for _, d := range t.Domains {
+		d = strings.ToLower(d)
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return false
+		}
+	}
+	log.Infof("%s: %s %s is not allowed by token %s", proxy.User, req.Method, req.URL.Host, t.ID)
+	proxy.errorPage(rw, req, http.StatusForbidden, "blocked", "token: "+t.ID)
+	return true
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"httpproxy/config"
+)
+
+// useTokenFile makes a new token file current for the rest of the test.
+func useTokenFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	initTokens(path)
+	t.Cleanup(func() { initTokens("") })
+	return path
+}
+
+func TestLookupToken(t *testing.T) {
+	useTokenFile(t)
+	s := &state{cnfg: config.Config{User: map[string]string{"alice": "x"}}}
+	valid, _ := IssueToken("alice", "", time.Hour, nil, 0)
+	expired, _ := IssueToken("alice", "", time.Nanosecond, nil, 0)
+	orphan, _ := IssueToken("bob", "", 0, nil, 0)
+	revoked, _ := IssueToken("alice", "", 0, nil, 0)
+	RevokeToken(hashToken(revoked)[:12])
+	time.Sleep(time.Millisecond)
+
+	tests := []struct {
+		name   string
+		secret string
+		ok     bool
+	}{
+		{"valid", valid, true},
+		{"expired", expired, false},
+		{"user gone", orphan, false},
+		{"revoked", revoked, false},
+		{"unknown", tokenPrefix + "nothing", false},
+		{"not a token", "password", false},
+	}
+	for _, tt := range tests {
+		if got := s.lookupToken(tt.secret); (got != nil) != tt.ok {
+			t.Errorf("%s: found %v, want %v", tt.name, got != nil, tt.ok)
+		}
+	}
+
+	// Tokens outlive reloads of the file.
+	path := tokensPath
+	initTokens("")
+	initTokens(path)
+	if s.lookupToken(valid) == nil {
+		t.Error("token lost when the file was read again")
+	}
+}
+
+func TestIssueTokenWithoutFile(t *testing.T) {
+	initTokens("")
+	if _, err := IssueToken("alice", "", 0, nil, 0); err == nil {
+		t.Error("token issued without a token file")
+	}
+}
+
+func TestTokenLimit(t *testing.T) {
+	useTokenFile(t)
+	s := &state{}
+	tests := []struct {
+		name    string
+		token   *config.Token
+		method  string
+		target  string
+		blocked bool
+		kind    string
+	}{
+		{"no token", nil, "GET", "http://any.com/", false, ""},
+		{"no domains", &config.Token{}, "GET", "http://any.com/", false, ""},
+		{"domain", &config.Token{Domains: []string{"Example.com"}}, "GET", "http://example.com/", false, ""},
+		{"subdomain", &config.Token{Domains: []string{"example.com"}}, "CONNECT", "www.example.com.:443", false, ""},
+		{"other domain", &config.Token{Domains: []string{"example.com"}}, "GET", "http://notexample.com/", true, "blocked"},
+		{"under quota", &config.Token{Quota: 100, Used: 99}, "GET", "http://any.com/", false, ""},
+		{"over quota", &config.Token{Quota: 100, Used: 100}, "GET", "http://any.com/", true, "quota"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		if tt.method == "CONNECT" {
+			req.URL.Host = tt.target
+		}
+		rw := httptest.NewRecorder()
+		proxy := &Handler{s: s, User: "alice", token: tt.token}
+		if got := proxy.TokenLimit(rw, req); got != tt.blocked {
+			t.Errorf("%s: blocked %v, want %v", tt.name, got, tt.blocked)
+			continue
+		}
+		if tt.blocked && (rw.Code != http.StatusForbidden || !strings.Contains(rw.Body.String(), `"error":"`+tt.kind+`"`)) {
+			t.Errorf("%s: answered %d %s", tt.name, rw.Code, rw.Body.String())
+		}
+	}
+}
+
+func TestTokenConn(t *testing.T) {
+	useTokenFile(t)
+	tok := &config.Token{ID: "t", Quota: 10}
+	client, server := net.Pipe()
+	defer server.Close()
+	proxy := &Handler{token: tok}
+	c := proxy.metered(client)
+	go func() {
+		b := make([]byte, 16)
+		for {
+			if _, err := server.Read(b); err != nil {
+				return
+			}
+		}
+	}()
+	tests := []struct {
+		data string
+		err  error
+	}{
+		{"1234", nil},
+		{"5678", nil},
+		{"90", errQuota},
+	}
+	for _, tt := range tests {
+		if _, err := c.Write([]byte(tt.data)); err != tt.err {
+			t.Errorf("writing %q: err = %v, want %v", tt.data, err, tt.err)
+		}
+	}
+	if tok.Used != 10 || proxy.charged.Load() != 10 {
+		t.Errorf("used %d, charged %d, want 10", tok.Used, proxy.charged.Load())
+	}
+	if _, err := c.Write([]byte("x")); err == nil {
+		t.Error("tunnel still open after the quota")
+	}
+	if (&Handler{token: &config.Token{}}).metered(client) != client {
+		t.Error("token without a quota is metered")
+	}
+}
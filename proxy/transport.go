@@ -7,82 +7,53 @@ import (
 	"net/url"
 )
 
+// NewProxyListener opens the listen address, exiting on failure.
 func NewProxyListener() net.Listener {
-	var ln net.Listener
-	listen, err := url.Parse(cnfg.Listen)
+	ln, err := ProxyListener(current.Load().cnfg.Listen)
 	if err != nil {
-		log.Error("%v", err)
-		return nil
-	}
-	q := listen.Query()
-	if q.Get("tls") != "" {
-		// Load Certificate
-		cert, err := tls.LoadX509KeyPair(q.Get("cert"), q.Get("key"))
-		if err != nil {
-			log.Fatal(err)
-		}
-		config := &tls.Config{
-			Certificates: []tls.Certificate{cert},
-			MinVersion: tls.VersionTLS12,
-			PreferServerCipherSuites: true,
-			CipherSuites: []uint16{
-				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
-				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
-				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-				tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
-				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
-			},
-		}
-		switch listen.Scheme {
-		case "unix":
-			ln, err = tls.Listen("unix", listen.Host + listen.Path, config)
-			if err1 := os.Chmod(listen.Host + listen.Path, 0666); err1 != nil {
-				log.Fatal(err1)
-			}
-		default:
-			ln, err = tls.Listen("tcp", listen.Host, config)
-		}
-	} else {
-		switch listen.Scheme {
-		case "unix":
-			ln, err = net.Listen(listen.Scheme, listen.Host + listen.Path)
-			if err1 := os.Chmod(listen.Host + listen.Path, 0666); err1 != nil {
-				log.Fatal(err1)
-			}
-		default:
-			ln, err = net.Listen("tcp", listen.Host)
-		}
+		log.Fatal("%v", err)
 	}
-	// Error
+	return ln
+}
+
+// NewWebListener opens the web listen address, exiting on failure.
+func NewWebListener() net.Listener {
+	ln, err := WebListener(current.Load().cnfg.WebListen)
 	if err != nil {
 		log.Fatal("%v", err)
-		return nil
 	}
 	return ln
 }
 
-func NewWebListener() net.Listener {
+// ProxyListener opens a listener for the proxy at addr.
+func ProxyListener(addr string) (net.Listener, error) {
+	// Offer HTTP/2 so tunnels can share one connection.
+	return listen(addr, []string{"h2", "http/1.1"})
+}
+
+// WebListener opens a listener for the web admin at addr.
+func WebListener(addr string) (net.Listener, error) {
+	return listen(addr, nil)
+}
+
+func listen(addr string, nextProtos []string) (net.Listener, error) {
 	var ln net.Listener
-	listen, err := url.Parse(cnfg.WebListen)
+	listen, err := url.Parse(addr)
 	if err != nil {
-		log.Error("%v", err)
-		return nil
+		return nil, err
 	}
 	q := listen.Query()
 	if q.Get("tls") != "" {
 		// Load Certificate
 		cert, err := tls.LoadX509KeyPair(q.Get("cert"), q.Get("key"))
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
 		config := &tls.Config{
 			Certificates: []tls.Certificate{cert},
 			MinVersion: tls.VersionTLS12,
 			PreferServerCipherSuites: true,
-			InsecureSkipVerify: true,
+			NextProtos: nextProtos,
 			CipherSuites: []uint16{
 				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
 				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
@@ -94,11 +65,14 @@ func NewWebListener() net.Listener {
 				tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
 			},
 		}
+		if err := setClientAuth(config, q); err != nil {
+			return nil, err
+		}
 		switch listen.Scheme {
 		case "unix":
-			ln, err = tls.Listen(listen.Scheme, listen.Host + listen.Path, config)
-			if err1 := os.Chmod(listen.Host + listen.Path, 0666); err1 != nil {
-				log.Fatal(err1)
+			ln, err = tls.Listen("unix", listen.Host + listen.Path, config)
+			if err == nil {
+				err = os.Chmod(listen.Host + listen.Path, 0666)
 			}
 		default:
 			ln, err = tls.Listen("tcp", listen.Host, config)
@@ -107,17 +81,18 @@ func NewWebListener() net.Listener {
 		switch listen.Scheme {
 		case "unix":
 			ln, err = net.Listen(listen.Scheme, listen.Host + listen.Path)
-			if err1 := os.Chmod(listen.Host + listen.Path, 0666); err1 != nil {
-				log.Fatal(err1)
+			if err == nil {
+				err = os.Chmod(listen.Host + listen.Path, 0666)
 			}
 		default:
 			ln, err = net.Listen("tcp", listen.Host)
 		}
 	}
-	// Error
 	if err != nil {
-		log.Fatal("%v", err)
-		return nil
+		if ln != nil {
+			ln.Close()
+		}
+		return nil, err
 	}
-	return ln
-}
\ No newline at end of file
+	return ln, nil
+}
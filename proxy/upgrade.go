@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+)
+
+//UpgradeHandler handles requests that switch protocols, such as WebSocket.
+//The handshake is forwarded and, once the backend answers 101, both
+//connections are spliced together.
+//处理协议升级请求，如 WebSocket
+func (proxy *Handler) UpgradeHandler(rw http.ResponseWriter, req *http.Request) {
+	upgrade := req.Header.Get("Upgrade")
+	log.Infof("%s is upgrading %s to %s", proxy.User, req.Host, upgrade)
+	SanitizeRequest(req)
+	RmProxyHeaders(req)
+	// Keep the handshake which RmProxyHeaders removed.
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", upgrade)
+	// The handshake carries no body; over HTTP/2 the body is the stream.
+	stream := req.Body
+	req.Body = http.NoBody
+
+	resp, err := proxy.roundTrip(req)
+	if err != nil {
+		log.Error(err)
+		proxy.upstreamError(rw, req, err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// The backend refused to switch, relay its answer.
+		ClearHeaders(rw.Header())
+		CopyHeaders(rw.Header(), resp.Header)
+		rw.WriteHeader(resp.StatusCode)
+		io.Copy(rw, resp.Body)
+		return
+	}
+	remote, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		log.Errorf("%s got a 101 response without a writable body", proxy.User)
+		proxy.errorPage(rw, req, http.StatusBadGateway, "upstream", "")
+		return
+	}
+
+	if req.ProtoMajor == 2 {
+		// Extended CONNECT answers 200 instead of 101.
+		for _, h := range []string{"Connection", "Upgrade", "Sec-Websocket-Accept"} {
+			resp.Header.Del(h)
+		}
+		CopyHeaders(rw.Header(), resp.Header)
+		rw.WriteHeader(http.StatusOK)
+		s := proxy.shaperFor(req.URL.Host)
+		splice(proxy.User, req.URL.Host, s.conn(proxy.metered(streamFor(rw, stream))), remote)
+		return
+	}
+
+	hj, _ := rw.(http.Hijacker)
+	client, brw, err := hj.Hijack() //获取客户端与代理服务器的tcp连接
+	if err != nil {
+		log.Errorf("%s failed to get Tcp connection of %s", proxy.User, req.RequestURI)
+		proxy.errorPage(rw, req, http.StatusInternalServerError, "upstream", "")
+		return
+	}
+	brw.WriteString("HTTP/1.1 " + resp.Status + "\r\n")
+	resp.Header.Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		client.Close()
+		return
+	}
+
+	s := proxy.shaperFor(req.URL.Host)
+	up, down := splice(proxy.User, req.URL.Host, s.conn(proxy.metered(&bufferedConn{client, brw.Reader})), remote)
+	proxy.rec.tunnelled(http.StatusSwitchingProtocols, up, down)
+}
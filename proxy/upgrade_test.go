@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"httpproxy/config"
+)
+
+func TestIsUpgrade(t *testing.T) {
+	tests := []struct {
+		connection []string
+		upgrade    string
+		want       bool
+	}{
+		{[]string{"Upgrade"}, "websocket", true},
+		{[]string{"keep-alive, upgrade"}, "websocket", true},
+		{[]string{"keep-alive", "Upgrade"}, "h2c", true},
+		{[]string{"keep-alive"}, "websocket", false},
+		{[]string{"Upgrade"}, "", false},
+		{nil, "websocket", false},
+		{[]string{"upgraded"}, "websocket", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.Header["Connection"] = tt.connection
+		if tt.upgrade != "" {
+			req.Header.Set("Upgrade", tt.upgrade)
+		}
+		if got := IsUpgrade(req); got != tt.want {
+			t.Errorf("Connection %q, Upgrade %q: got %v, want %v", tt.connection, tt.upgrade, got, tt.want)
+		}
+	}
+}
+
+// echoUpgrade switches to an echo protocol, or refuses with 400 when
+// the client does not ask for it.
+func echoUpgrade(rw http.ResponseWriter, req *http.Request) {
+	if req.Header.Get("Upgrade") != "echo" || !IsUpgrade(req) {
+		http.Error(rw, "echo only", http.StatusBadRequest)
+		return
+	}
+	conn, brw, err := rw.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	brw.Flush()
+	io.Copy(conn, brw)
+}
+
+func TestUpgradeHandler(t *testing.T) {
+	useConfig(t, config.Config{Egress: config.Egress{Disable: true}})
+	backend := httptest.NewServer(http.HandlerFunc(echoUpgrade))
+	defer backend.Close()
+	proxy := httptest.NewServer(&Handler{Tr: &http.Transport{}})
+	defer proxy.Close()
+
+	tests := []struct {
+		name    string
+		upgrade string
+		status  int
+	}{
+		{"switched", "echo", http.StatusSwitchingProtocols},
+		{"refused", "other", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(proxy.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		req, _ := http.NewRequest("GET", backend.URL+"/", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", tt.upgrade)
+		req.WriteProxy(conn)
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, resp.StatusCode, tt.status)
+			continue
+		}
+		if tt.status != http.StatusSwitchingProtocols {
+			continue
+		}
+		if resp.Header.Get("Upgrade") != "echo" {
+			t.Errorf("%s: Upgrade %q", tt.name, resp.Header.Get("Upgrade"))
+		}
+		conn.Write([]byte("ping"))
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(br, b); err != nil || string(b) != "ping" {
+			t.Errorf("%s: read %q, %v after the switch", tt.name, b, err)
+		}
+	}
+}
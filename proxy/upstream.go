@@ -0,0 +1,323 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"httpproxy/config"
+)
+
+// member is one server of an upstream pool.
+type member struct {
+	addr   string
+	weight int
+	// active counts requests in flight.
+	active int64
+	// down is set by the health checker.
+	down int32
+	// current is the smooth weighted round-robin state.
+	current int
+	// fails and oks count consecutive check results.
+	fails, oks int
+}
+
+func (m *member) healthy() bool {
+	return atomic.LoadInt32(&m.down) == 0
+}
+
+// upstreamPool balances requests between its members.
+type upstreamPool struct {
+	name     string
+	strategy string
+	hashKey  string
+	check    config.HealthCheck
+	members  []*member
+	next     uint64
+	mu       sync.Mutex
+	stop     chan struct{}
+}
+
+func newUpstreamPool(name string, u config.Upstream) *upstreamPool {
+	pool := &upstreamPool{
+		name:     name,
+		strategy: u.Strategy,
+		hashKey:  u.HashKey,
+		check:    u.HealthCheck,
+		stop:     make(chan struct{}),
+	}
+	if pool.strategy == "" {
+		pool.strategy = "round_robin"
+	}
+	if pool.hashKey == "" {
+		pool.hashKey = "ip"
+	}
+	for _, s := range u.Servers {
+		w := s.Weight
+		if w <= 0 {
+			w = 1
+		}
+		pool.members = append(pool.members, &member{addr: s.Addr, weight: w})
+	}
+	return pool
+}
+
+// newUpstreams builds the pools of c and starts their health checks.
+func newUpstreams(c *config.Config) map[string]*upstreamPool {
+	upstreams := make(map[string]*upstreamPool)
+	for name, u := range c.Upstreams {
+		if len(u.Servers) == 0 {
+			log.Warningf("upstream %s has no servers, ignored", name)
+			continue
+		}
+		pool := newUpstreamPool(name, u)
+		upstreams[name] = pool
+		if pool.check.Type != "" {
+			go pool.healthCheck()
+		}
+	}
+	return upstreams
+}
+
+// stopUpstreams stops the health checks of pools that were replaced.
+func stopUpstreams(upstreams map[string]*upstreamPool) {
+	for _, pool := range upstreams {
+		close(pool.stop)
+	}
+}
+
+// upstreamAddr resolves pass, which is either an address or the name of an
+// upstream pool, to the address the request should be sent to.
+// The returned function must be called when the request is done.
+func (s *state) upstreamAddr(pass string, req *http.Request) (string, func()) {
+	pool, ok := s.upstreams[pass]
+	if !ok {
+		return pass, func() {}
+	}
+	m := pool.pick(req)
+	atomic.AddInt64(&m.active, 1)
+	return m.addr, func() { atomic.AddInt64(&m.active, -1) }
+}
+
+// pick chooses a member according to the pool's strategy.
+// When every member is down, all of them are considered.
+func (pool *upstreamPool) pick(req *http.Request) *member {
+	candidates := make([]*member, 0, len(pool.members))
+	for _, m := range pool.members {
+		if m.healthy() {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = pool.members
+	}
+
+	switch pool.strategy {
+	case "least_conn":
+		best := candidates[0]
+		for _, m := range candidates[1:] {
+			// compare active/weight without division
+			if atomic.LoadInt64(&m.active)*int64(best.weight) < atomic.LoadInt64(&best.active)*int64(m.weight) {
+				best = m
+			}
+		}
+		return best
+	case "weighted":
+		return pool.pickWeighted(candidates)
+	case "hash":
+		return pickHash(candidates, pool.stickyKey(req))
+	default:
+		n := atomic.AddUint64(&pool.next, 1)
+		return candidates[(n-1)%uint64(len(candidates))]
+	}
+}
+
+// pickWeighted implements smooth weighted round-robin.
+func (pool *upstreamPool) pickWeighted(candidates []*member) *member {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	var best *member
+	total := 0
+	for _, m := range candidates {
+		m.current += m.weight
+		total += m.weight
+		if best == nil || m.current > best.current {
+			best = m
+		}
+	}
+	best.current -= total
+	return best
+}
+
+// stickyKey returns the value requests are hashed on.
+func (pool *upstreamPool) stickyKey(req *http.Request) string {
+	if strings.HasPrefix(pool.hashKey, "cookie:") {
+		if c, err := req.Cookie(strings.TrimPrefix(pool.hashKey, "cookie:")); err == nil {
+			return c.Value
+		}
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// pickHash uses weighted rendezvous hashing, so only the keys of a
+// removed member move when the membership changes, and each member gets
+// keys in proportion to its weight.
+func pickHash(candidates []*member, key string) *member {
+	var best *member
+	bestScore := math.Inf(-1)
+	for _, m := range candidates {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		h.Write([]byte{0})
+		h.Write([]byte(m.addr))
+		// The hash as a number in (0, 1), from its top 53 bits. FNV
+		// mixes the high bits poorly, so they are mixed again first.
+		u := (float64(fmix64(h.Sum64())>>11) + 0.5) / (1 << 53)
+		score := -float64(m.weight) / math.Log(u)
+		if best == nil || score > bestScore {
+			best, bestScore = m, score
+		}
+	}
+	return best
+}
+
+// fmix64 is the final mix of MurmurHash3.
+func fmix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// healthCheck probes the members until the pool is replaced.
+func (pool *upstreamPool) healthCheck() {
+	interval := time.Duration(pool.check.Interval) * time.Second
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for _, m := range pool.members {
+			pool.probe(m)
+		}
+		select {
+		case <-ticker.C:
+		case <-pool.stop:
+			return
+		}
+	}
+}
+
+// probe checks a member once, ejecting or re-admitting it when the
+// fall or rise threshold is reached.
+func (pool *upstreamPool) probe(m *member) {
+	fall, rise := pool.check.Fall, pool.check.Rise
+	if fall <= 0 {
+		fall = 3
+	}
+	if rise <= 0 {
+		rise = 2
+	}
+
+	if err := pool.checkMember(m); err != nil {
+		m.oks = 0
+		m.fails++
+		if m.fails >= fall && m.healthy() {
+			log.Warningf("upstream %s: %s is down: %v", pool.name, m.addr, err)
+			atomic.StoreInt32(&m.down, 1)
+		}
+		return
+	}
+	m.fails = 0
+	m.oks++
+	if m.oks >= rise && !m.healthy() {
+		log.Infof("upstream %s: %s is up", pool.name, m.addr)
+		atomic.StoreInt32(&m.down, 0)
+	}
+}
+
+func (pool *upstreamPool) checkMember(m *member) error {
+	timeout := time.Duration(pool.check.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+
+	if pool.check.Type == "tcp" {
+		conn, err := net.DialTimeout("tcp", m.addr, timeout)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	path := pool.check.Path
+	if path == "" {
+		path = "/"
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get("http://" + m.addr + path)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// UpstreamStatus describes an upstream pool for the web admin.
+type UpstreamStatus struct {
+	Name     string
+	Strategy string
+	Members  []MemberStatus
+}
+
+// MemberStatus describes one server of an upstream pool.
+type MemberStatus struct {
+	Addr    string
+	Weight  int
+	Healthy bool
+	Active  int64
+}
+
+// UpstreamStatuses returns the state of every pool, sorted by name.
+func UpstreamStatuses() []UpstreamStatus {
+	upstreams := current.Load().upstreams
+	statuses := make([]UpstreamStatus, 0, len(upstreams))
+	for _, pool := range upstreams {
+		s := UpstreamStatus{Name: pool.name, Strategy: pool.strategy}
+		for _, m := range pool.members {
+			s.Members = append(s.Members, MemberStatus{
+				Addr:    m.addr,
+				Weight:  m.weight,
+				Healthy: m.healthy(),
+				Active:  atomic.LoadInt64(&m.active),
+			})
+		}
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
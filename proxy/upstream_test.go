@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"httpproxy/config"
+)
+
+func TestUpstreamPick(t *testing.T) {
+	servers := []config.UpstreamServer{{Addr: "a", Weight: 5}, {Addr: "b", Weight: 1}, {Addr: "c", Weight: 1}}
+	tests := []struct {
+		name     string
+		strategy string
+		down     string // the member marked down
+		want     string // the members picked, in order
+	}{
+		{"round robin", "", "", "abcabca"},
+		{"round robin, one down", "round_robin", "b", "acacaca"},
+		// Smooth weighted round-robin spreads the heavy member out.
+		{"weighted", "weighted", "", "aabacaaaabaca"},
+		{"weighted, one down", "weighted", "a", "bcbcbc"},
+	}
+	for _, tt := range tests {
+		pool := newUpstreamPool("test", config.Upstream{Strategy: tt.strategy, Servers: servers})
+		for _, m := range pool.members {
+			if m.addr == tt.down {
+				m.down = 1
+			}
+		}
+		var got strings.Builder
+		for range tt.want {
+			got.WriteString(pool.pick(httptest.NewRequest("GET", "/", nil)).addr)
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s: picked %s, want %s", tt.name, got.String(), tt.want)
+		}
+	}
+}
+
+func TestUpstreamPickAllDown(t *testing.T) {
+	pool := newUpstreamPool("test", config.Upstream{Servers: []config.UpstreamServer{{Addr: "a"}, {Addr: "b"}}})
+	for _, m := range pool.members {
+		m.down = 1
+	}
+	if m := pool.pick(httptest.NewRequest("GET", "/", nil)); m == nil {
+		t.Error("no member picked when all are down")
+	}
+}
+
+func TestUpstreamLeastConn(t *testing.T) {
+	pool := newUpstreamPool("test", config.Upstream{Strategy: "least_conn",
+		Servers: []config.UpstreamServer{{Addr: "a", Weight: 2}, {Addr: "b", Weight: 1}}})
+	tests := []struct {
+		active map[string]int64
+		want   string
+	}{
+		{map[string]int64{"a": 0, "b": 0}, "a"},
+		{map[string]int64{"a": 1, "b": 0}, "b"},
+		{map[string]int64{"a": 1, "b": 1}, "a"}, // 1/2 < 1/1
+		{map[string]int64{"a": 4, "b": 1}, "b"},
+		{map[string]int64{"a": 3, "b": 2}, "a"},
+	}
+	for _, tt := range tests {
+		for _, m := range pool.members {
+			m.active = tt.active[m.addr]
+		}
+		if m := pool.pick(httptest.NewRequest("GET", "/", nil)); m.addr != tt.want {
+			t.Errorf("active %v: picked %s, want %s", tt.active, m.addr, tt.want)
+		}
+	}
+}
+
+func TestPickHash(t *testing.T) {
+	tests := []struct {
+		weights []int
+		removed int // index of a member removed afterwards, -1 for none
+	}{
+		{[]int{1, 1, 1}, -1},
+		{[]int{3, 1}, -1},
+		{[]int{1, 2, 5}, 1},
+		{[]int{1, 1, 1, 1}, 0},
+	}
+	const keys = 20000
+	for _, tt := range tests {
+		var members []*member
+		total := 0
+		for i, w := range tt.weights {
+			members = append(members, &member{addr: fmt.Sprintf("10.0.0.%d:80", i), weight: w})
+			total += w
+		}
+		counts := make(map[*member]int)
+		picked := make([]*member, keys)
+		for k := 0; k < keys; k++ {
+			picked[k] = pickHash(members, fmt.Sprint("client-", k))
+			counts[picked[k]]++
+		}
+		// Each member gets its share of the keys, give or take 10%.
+		for _, m := range members {
+			want := keys * m.weight / total
+			if d := counts[m] - want; d > want/10 || d < -want/10 {
+				t.Errorf("weights %v: %s got %d keys, want about %d", tt.weights, m.addr, counts[m], want)
+			}
+		}
+		if tt.removed < 0 {
+			continue
+		}
+		gone := members[tt.removed]
+		rest := append(append([]*member{}, members[:tt.removed]...), members[tt.removed+1:]...)
+		for k := 0; k < keys; k++ {
+			if m := pickHash(rest, fmt.Sprint("client-", k)); picked[k] != gone && m != picked[k] {
+				t.Errorf("weights %v: key %d moved from %s to %s", tt.weights, k, picked[k].addr, m.addr)
+				break
+			}
+		}
+	}
+}
+
+func TestStickyKey(t *testing.T) {
+	tests := []struct {
+		hashKey string
+		cookie  string
+		want    string
+	}{
+		{"", "", "192.0.2.1"},
+		{"ip", "", "192.0.2.1"},
+		{"cookie:sid", "sid=abc", "abc"},
+		{"cookie:sid", "other=abc", "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		pool := newUpstreamPool("test", config.Upstream{Strategy: "hash", HashKey: tt.hashKey,
+			Servers: []config.UpstreamServer{{Addr: "a"}}})
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if tt.cookie != "" {
+			req.Header.Set("Cookie", tt.cookie)
+		}
+		if got := pool.stickyKey(req); got != tt.want {
+			t.Errorf("hash key %q, cookie %q: got %q, want %q", tt.hashKey, tt.cookie, got, tt.want)
+		}
+	}
+}
+
+func TestUpstreamProbe(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(status)
+	}))
+	defer srv.Close()
+	pool := newUpstreamPool("test", config.Upstream{
+		Servers:     []config.UpstreamServer{{Addr: strings.TrimPrefix(srv.URL, "http://")}},
+		HealthCheck: config.HealthCheck{Type: "http", Fall: 2, Rise: 2},
+	})
+	m := pool.members[0]
+	tests := []struct {
+		status int
+		up     bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusInternalServerError, true},
+		{http.StatusInternalServerError, false},
+		{http.StatusOK, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusOK, false},
+		{http.StatusOK, true},
+	}
+	var got, want []bool
+	for _, tt := range tests {
+		status = tt.status
+		pool.probe(m)
+		got, want = append(got, m.healthy()), append(want, tt.up)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("healthy after each probe: %v, want %v", got, want)
+	}
+}
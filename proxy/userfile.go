@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"httpproxy/config"
+)
+
+var (
+	fileUsersMu  sync.RWMutex
+	fileUsers    map[string]string
+	userFileStop chan struct{}
+)
+
+// initUserFile loads the user file f and watches it for changes.
+func initUserFile(f config.UserFile) {
+	if userFileStop != nil {
+		close(userFileStop)
+		userFileStop = nil
+	}
+	if f.Path == "" {
+		fileUsersMu.Lock()
+		fileUsers = nil
+		fileUsersMu.Unlock()
+		return
+	}
+	interval := time.Duration(f.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	var modTime time.Time
+	load := func() {
+		fi, err := os.Stat(f.Path)
+		if err != nil {
+			log.Errorf("user file: %v", err)
+			return
+		}
+		if fi.ModTime().Equal(modTime) {
+			return
+		}
+		users, err := config.ReadUserFile(f)
+		if err != nil {
+			// Keep the users loaded before, the file may be half written.
+			log.Errorf("user file %s: %v", f.Path, err)
+			return
+		}
+		modTime = fi.ModTime()
+		fileUsersMu.Lock()
+		fileUsers = users
+		fileUsersMu.Unlock()
+		log.Infof("loaded %d users from %s", len(users), f.Path)
+	}
+	load()
+
+	stop := make(chan struct{})
+	userFileStop = stop
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				load()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// lookupUser returns the password hash of user, from the config or
+// else from the user file.
+func (s *state) lookupUser(user string) (string, bool) {
+	if hash, ok := s.cnfg.User[user]; ok {
+		return hash, true
+	}
+	fileUsersMu.RLock()
+	defer fileUsersMu.RUnlock()
+	hash, ok := fileUsers[user]
+	return hash, ok
+}
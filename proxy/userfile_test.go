@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"httpproxy/config"
+)
+
+func TestLookupUser(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.json")
+	write := func(body string, mtime time.Time) {
+		if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+	f := config.UserFile{Path: path, Interval: 3600}
+	t.Cleanup(func() { initUserFile(config.UserFile{}) })
+	s := &state{cnfg: config.Config{User: map[string]string{"alice": "from-config"}}}
+
+	write(`{"alice":"from-file","bob":"b1"}`, time.Unix(1000, 0))
+	initUserFile(f)
+	// A file which does not parse keeps the users loaded before.
+	write(`{"bob":`, time.Unix(2000, 0))
+	initUserFile(f)
+
+	tests := []struct {
+		user, want string
+		ok         bool
+	}{
+		{"alice", "from-config", true},
+		{"bob", "b1", true},
+		{"carol", "", false},
+	}
+	check := func(when string) {
+		for _, tt := range tests {
+			got, ok := s.lookupUser(tt.user)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("%s: lookupUser(%q) = %q, %v, want %q, %v", when, tt.user, got, ok, tt.want, tt.ok)
+			}
+		}
+	}
+	check("bad file")
+
+	write(`{"bob":"b2","carol":"c"}`, time.Unix(3000, 0))
+	initUserFile(f)
+	tests[1].want = "b2"
+	tests[2].want, tests[2].ok = "c", true
+	check("changed file")
+
+	initUserFile(config.UserFile{})
+	tests[1].want, tests[1].ok = "", false
+	tests[2].want, tests[2].ok = "", false
+	check("no file")
+}
@@ -2,15 +2,26 @@ package proxy
 
 import (
 	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"httpproxy/config"
 )
 
-type WebServer struct{}
+// WebServer serves the web admin.
+// ServeHTTP works on a copy of it, so the logged in admin is not shared.
+type WebServer struct {
+	// Admin is the name the admin logged in with
+	Admin string
+	// role is the account of the admin
+	role config.Admin
+	// s is the state the request is served with
+	s *state
+}
 
 func NewWebServer() *WebServer {
 	return &WebServer{}
@@ -18,6 +29,19 @@ func NewWebServer() *WebServer {
 
 // ServeHTTP handles web admin pages
 func (ws *WebServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	session := *ws
+	ws = &session
+	ws.s = current.Load()
+	if req.URL.Path == "/metrics" {
+		ws.MetricsHandler(rw, req)
+		return
+	}
+	// Paths are not cleaned before routing, so "/static/../config" would
+	// reach files outside the static directory.
+	if strings.Contains(req.URL.Path, "..") {
+		http.NotFound(rw, req)
+		return
+	}
 	if err := ws.WebAuth(rw, req); err != nil {
 		log.Debug("%v", err)
 		return
@@ -31,12 +55,28 @@ func (ws *WebServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 		s := strings.Split(p, "/")
 		switch s[0] {
 		case "static":
-			hadler := http.FileServer(http.Dir("."))
+			hadler := http.StripPrefix("/static/", http.FileServer(http.Dir("static")))
 			hadler.ServeHTTP(rw, req)
 		case "user":
 			ws.UserHandler(rw, req)
 		case "setting":
 			ws.SettingHandler(rw, req)
+		case "upstream":
+			ws.UpstreamHandler(rw, req)
+		case "bandwidth":
+			ws.BandwidthHandler(rw, req)
+		case "limit":
+			ws.LimitHandler(rw, req)
+		case "token":
+			ws.TokenHandler(rw, req)
+		case "lockout":
+			ws.LockoutHandler(rw, req)
+		case "errorpage":
+			ws.ErrorPageHandler(rw, req)
+		case "blocklist":
+			ws.BlocklistHandler(rw, req)
+		case "reload":
+			ws.ReloadHandler(rw, req)
 		}
 	}
 }
@@ -44,6 +84,25 @@ func (ws *WebServer) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 type data struct {
 	config.Config
 	Nav string
+	// Admin and Role tell who is logged in
+	Admin string
+	Role  string
+}
+
+// page returns the data of the page nav for the logged in admin.
+func (ws *WebServer) page(nav string) data {
+	return data{ws.s.cnfg, nav, ws.Admin, ws.role.Role}
+}
+
+// allow answers 403 and returns false unless the admin has the rights
+// of role.
+func (ws *WebServer) allow(rw http.ResponseWriter, req *http.Request, role string) bool {
+	if ws.role.Can(role) {
+		return true
+	}
+	log.Warningf("admin %s (%s) is not allowed to %s %s", ws.Admin, ws.role.Role, req.Method, req.URL.Path)
+	http.Error(rw, "Forbidden", http.StatusForbidden)
+	return false
 }
 
 // HomeHandler handles web home page
@@ -55,7 +114,7 @@ func (ws *WebServer) HomeHandler(rw http.ResponseWriter, req *http.Request) {
 		http.Error(rw, "tpl error", 500)
 		return
 	}
-	Data := data{cnfg, "home"}
+	Data := ws.page("home")
 	err = t.Execute(rw, Data)
 	if err != nil {
 		log.Error(err)
@@ -74,6 +133,9 @@ func (ws *WebServer) UserHandler(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	user := s[2]
+	if s[1] != "list" && !ws.allow(rw, req, config.RoleUserManager) {
+		return
+	}
 	switch s[1] {
 	case "list": //list all users
 		t := template.New("layout.tpl")
@@ -83,7 +145,7 @@ func (ws *WebServer) UserHandler(rw http.ResponseWriter, req *http.Request) {
 			http.Error(rw, "tpl error", 500)
 			return
 		}
-		Data := data{cnfg, "user"}
+		Data := ws.page("user")
 		err = t.Execute(rw, Data)
 		if err != nil {
 			log.Error(err)
@@ -93,22 +155,37 @@ func (ws *WebServer) UserHandler(rw http.ResponseWriter, req *http.Request) {
 	case "modify": //modify specific user
 		passwd := req.FormValue("passwd")
 		if passwd != "" {
-			cnfg.User[user] = passwd
+			if err := update(func(c *config.Config) error {
+				return c.SetPassword(user, passwd)
+			}); err != nil {
+				log.Error(err)
+				http.Error(rw, "post error", 500)
+				return
+			}
 		}
 	case "delete": //delete specific user
-		delete(cnfg.User, user)
+		if err := update(func(c *config.Config) error {
+			c.DeleteUser(user)
+			return nil
+		}); err != nil {
+			log.Error(err)
+			http.Error(rw, "post error", 500)
+			return
+		}
+		revokeTokensOf(user)
 	case "add": //add new user
 		user := req.FormValue("user")
 		passwd := req.FormValue("passwd")
-		if cnfg.User[user] != "" {
+		if err := update(func(c *config.Config) error {
+			if c.User[user] != "" {
+				return fmt.Errorf("user %s exists", user)
+			}
+			return c.SetPassword(user, passwd)
+		}); err != nil {
+			log.Error(err)
 			http.Error(rw, "post error", 500)
 			return
 		}
-		cnfg.User[user] = passwd
-	}
-	err := cnfg.WriteToFile()
-	if err != nil {
-		log.Error(err)
 	}
 }
 
@@ -129,7 +206,7 @@ func (ws *WebServer) SettingHandler(rw http.ResponseWriter, req *http.Request) {
 			http.Error(rw, "tpl error", 500)
 			return
 		}
-		Data := data{cnfg, "setting"}
+		Data := ws.page("setting")
 		err = t.Execute(rw, Data)
 		if err != nil {
 			log.Error(err)
@@ -137,46 +214,395 @@ func (ws *WebServer) SettingHandler(rw http.ResponseWriter, req *http.Request) {
 			return
 		}
 	case "set":
+		if !ws.allow(rw, req, config.RoleAdmin) {
+			return
+		}
 		auth := req.FormValue("auth")
 		cache := req.FormValue("cache")
 		cachetimeout := req.FormValue("cachetimeout")
 		failover := req.FormValue("failover")
 		gfwlist := req.FormValue("gfwlist")
 		logging, _ := strconv.Atoi(req.FormValue("log"))
-		//TODO check those value
-		if auth == "true" {
-			cnfg.Auth = true
-		}
-		if cache == "true" {
-			cnfg.Cache = true
-		}
-		ctint, _ := strconv.Atoi(cachetimeout)
-		cnfg.CacheTimeout = int64(ctint)
-		gfwlist = strings.Trim(gfwlist, ";")
-		cnfg.GFWList = strings.Split(gfwlist, ";")
-		cnfg.Failover = failover
-		cnfg.Log = logging
-		err := cnfg.WriteToFile()
+		err := update(func(c *config.Config) error {
+			if auth == "true" {
+				c.Auth = true
+			}
+			if cache == "true" {
+				c.Cache = true
+			}
+			ctint, _ := strconv.Atoi(cachetimeout)
+			c.CacheTimeout = int64(ctint)
+			// One rule per line, as schedules contain ";".
+			c.GFWList = []string{}
+			for _, rule := range strings.Split(gfwlist, "\n") {
+				if rule = strings.TrimSpace(rule); rule != "" {
+					c.GFWList = append(c.GFWList, rule)
+				}
+			}
+			c.Failover = failover
+			c.Log = logging
+			return nil
+		})
 		if err != nil {
 			log.Error(err)
+			http.Error(rw, err.Error(), 500)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+}
+
+type tokenData struct {
+	data
+	Tokens []config.Token
+}
+
+// TokenHandler lists, issues and revokes access tokens.
+func (ws *WebServer) TokenHandler(rw http.ResponseWriter, req *http.Request) {
+	p := strings.Trim(req.URL.Path, "/")
+	s := strings.Split(p, "/")
+	if len(s) < 2 {
+		s = append(s, "list")
+	}
+	if s[1] != "list" && !ws.allow(rw, req, config.RoleUserManager) {
+		return
+	}
+	switch s[1] {
+	case "list":
+		t := template.New("layout.tpl")
+		t, err := t.ParseFiles("views/layout.tpl", "views/token.tpl")
+		if err != nil {
+			log.Error(err)
+			http.Error(rw, "tpl error", 500)
+			return
+		}
+		Data := tokenData{ws.page("token"), Tokens()}
+		err = t.Execute(rw, Data)
+		if err != nil {
+			log.Error(err)
+			http.Error(rw, "tpl error", 500)
+			return
+		}
+	case "issue":
+		user := req.FormValue("user")
+		hours, err1 := strconv.Atoi("0" + req.FormValue("hours"))
+		quota, err2 := strconv.ParseInt("0"+req.FormValue("quota"), 10, 64)
+		if user == "" || err1 != nil || err2 != nil {
+			http.Error(rw, "post error", 500)
+			return
+		}
+		if _, ok := ws.s.lookupUser(user); !ok {
+			http.Error(rw, "unknown user "+user, 500)
+			return
 		}
+		var domains []string
+		for _, d := range strings.Split(req.FormValue("domains"), ";") {
+			if d = strings.TrimSpace(d); d != "" {
+				domains = append(domains, d)
+			}
+		}
+		secret, err := IssueToken(user, req.FormValue("note"), time.Duration(hours)*time.Hour, domains, quota)
+		if err != nil {
+			http.Error(rw, err.Error(), 500)
+			return
+		}
+		rw.Write([]byte(secret))
+	case "revoke":
+		if len(s) < 3 {
+			http.Error(rw, "request error", 500)
+			return
+		}
+		RevokeToken(s[2])
+	}
+}
+
+type errorPageData struct {
+	data
+	Kinds []string
+	Text  string
+}
+
+// ErrorPageHandler shows and edits the error page template.
+func (ws *WebServer) ErrorPageHandler(rw http.ResponseWriter, req *http.Request) {
+	p := strings.Trim(req.URL.Path, "/")
+	s := strings.Split(p, "/")
+	if len(s) == 2 && s[1] == "save" {
+		if !ws.allow(rw, req, config.RoleAdmin) {
+			return
+		}
+		if err := SetErrorPage(req.FormValue("text")); err != nil {
+			http.Error(rw, err.Error(), 500)
+			return
+		}
+		log.Infof("admin %s changed the error page", ws.Admin)
 		rw.WriteHeader(http.StatusOK)
+		return
+	}
+
+	text, err := ErrorPage()
+	if err != nil {
+		log.Error(err)
+	}
+	t := template.New("layout.tpl")
+	t, err = t.ParseFiles("views/layout.tpl", "views/errorpage.tpl")
+	if err != nil {
+		log.Error(err)
+		http.Error(rw, "tpl error", 500)
+		return
+	}
+	Data := errorPageData{ws.page("errorpage"), ErrorKinds(), text}
+	err = t.Execute(rw, Data)
+	if err != nil {
+		log.Error(err)
+		http.Error(rw, "tpl error", 500)
+		return
+	}
+}
+
+type blocklistData struct {
+	data
+	Lists []BlocklistStatus
+}
+
+// BlocklistHandler lists the subscribed blocklists and refreshes them.
+func (ws *WebServer) BlocklistHandler(rw http.ResponseWriter, req *http.Request) {
+	p := strings.Trim(req.URL.Path, "/")
+	s := strings.SplitN(p, "/", 3)
+	if len(s) == 3 && s[1] == "refresh" {
+		if !ws.allow(rw, req, config.RoleAdmin) {
+			return
+		}
+		RefreshBlocklist(s[2])
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+
+	t := template.New("layout.tpl")
+	t, err := t.ParseFiles("views/layout.tpl", "views/blocklist.tpl")
+	if err != nil {
+		log.Error(err)
+		http.Error(rw, "tpl error", 500)
+		return
+	}
+	Data := blocklistData{ws.page("blocklist"), BlocklistStatuses()}
+	err = t.Execute(rw, Data)
+	if err != nil {
+		log.Error(err)
+		http.Error(rw, "tpl error", 500)
+		return
+	}
+}
+
+type lockoutData struct {
+	data
+	Bans []BanStatus
+}
+
+// LockoutHandler lists clients with failed logins and lifts bans.
+func (ws *WebServer) LockoutHandler(rw http.ResponseWriter, req *http.Request) {
+	p := strings.Trim(req.URL.Path, "/")
+	s := strings.SplitN(p, "/", 3)
+	if len(s) == 3 && s[1] == "lift" {
+		if !ws.allow(rw, req, config.RoleUserManager) {
+			return
+		}
+		LiftBan(s[2])
+		rw.WriteHeader(http.StatusOK)
+		return
+	}
+
+	t := template.New("layout.tpl")
+	t, err := t.ParseFiles("views/layout.tpl", "views/lockout.tpl")
+	if err != nil {
+		log.Error(err)
+		http.Error(rw, "tpl error", 500)
+		return
+	}
+	Data := lockoutData{ws.page("lockout"), BanStatuses()}
+	err = t.Execute(rw, Data)
+	if err != nil {
+		log.Error(err)
+		http.Error(rw, "tpl error", 500)
+		return
+	}
+}
+
+// ReloadHandler re-reads the config file, like SIGHUP.
+func (ws *WebServer) ReloadHandler(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		http.Error(rw, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !ws.allow(rw, req, config.RoleAdmin) {
+		return
+	}
+	if err := Reload(); err != nil {
+		http.Error(rw, err.Error(), 500)
+		return
+	}
+	rw.WriteHeader(http.StatusOK)
+}
+
+type upstreamData struct {
+	data
+	Pools []UpstreamStatus
+}
+
+// UpstreamHandler shows the state of the upstream pools.
+func (ws *WebServer) UpstreamHandler(rw http.ResponseWriter, req *http.Request) {
+	t := template.New("layout.tpl")
+	t, err := t.ParseFiles("views/layout.tpl", "views/upstream.tpl")
+	if err != nil {
+		log.Error(err)
+		http.Error(rw, "tpl error", 500)
+		return
+	}
+	Data := upstreamData{ws.page("upstream"), UpstreamStatuses()}
+	err = t.Execute(rw, Data)
+	if err != nil {
+		log.Error(err)
+		http.Error(rw, "tpl error", 500)
+		return
+	}
+}
+
+// BandwidthHandler allows admin to view and change bandwidth limits.
+// Changes take effect on transfers in progress as well.
+func (ws *WebServer) BandwidthHandler(rw http.ResponseWriter, req *http.Request) {
+	p := strings.Trim(req.URL.Path, "/")
+	s := strings.Split(p, "/")
+	if len(s) < 2 {
+		http.Error(rw, "request error", 500)
+		return
+	}
+	if s[1] != "list" && !ws.allow(rw, req, config.RoleAdmin) {
+		return
+	}
+	scope := req.FormValue("scope")
+	name := req.FormValue("name")
+	switch s[1] {
+	case "list":
+		t := template.New("layout.tpl")
+		t, err := t.ParseFiles("views/layout.tpl", "views/bandwidth.tpl")
+		if err != nil {
+			log.Error(err)
+			http.Error(rw, "tpl error", 500)
+			return
+		}
+		Data := ws.page("bandwidth")
+		err = t.Execute(rw, Data)
+		if err != nil {
+			log.Error(err)
+			http.Error(rw, "tpl error", 500)
+			return
+		}
+		return
+	case "set":
+		upload, err1 := strconv.ParseInt(req.FormValue("upload"), 10, 64)
+		download, err2 := strconv.ParseInt(req.FormValue("download"), 10, 64)
+		if err1 != nil || err2 != nil || upload < 0 || download < 0 {
+			http.Error(rw, "post error", 500)
+			return
+		}
+		bw := config.Bandwidth{Upload: upload, Download: download}
+		err := update(func(c *config.Config) error {
+			switch {
+			case scope == "global":
+				c.Bandwidth = bw
+			case scope == "user" && name != "":
+				if c.Profiles == nil {
+					c.Profiles = make(map[string]config.Profile)
+				}
+				profile := c.Profiles[name]
+				profile.Bandwidth = bw
+				c.Profiles[name] = profile
+			case scope == "domain" && name != "":
+				if c.DomainBandwidth == nil {
+					c.DomainBandwidth = make(map[string]config.Bandwidth)
+				}
+				c.DomainBandwidth[name] = bw
+			default:
+				return errors.New("unknown bandwidth scope " + scope)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Error(err)
+			http.Error(rw, "post error", 500)
+			return
+		}
+	case "delete":
+		err := update(func(c *config.Config) error {
+			switch scope {
+			case "user":
+				if profile, ok := c.Profiles[name]; ok {
+					profile.Bandwidth = config.Bandwidth{}
+					c.Profiles[name] = profile
+				}
+			case "domain":
+				delete(c.DomainBandwidth, name)
+			}
+			return nil
+		})
+		if err != nil {
+			log.Error(err)
+			http.Error(rw, "post error", 500)
+			return
+		}
+	}
+}
+
+type limitData struct {
+	data
+	Counters []LimitStatus
+}
+
+// LimitHandler shows the concurrency counters of users and client IPs.
+func (ws *WebServer) LimitHandler(rw http.ResponseWriter, req *http.Request) {
+	t := template.New("layout.tpl")
+	t, err := t.ParseFiles("views/layout.tpl", "views/limit.tpl")
+	if err != nil {
+		log.Error(err)
+		http.Error(rw, "tpl error", 500)
+		return
+	}
+	Data := limitData{ws.page("limit"), LimitStatuses()}
+	err = t.Execute(rw, Data)
+	if err != nil {
+		log.Error(err)
+		http.Error(rw, "tpl error", 500)
+		return
 	}
 }
 
 // WebAuth checks the authorization
 func (ws *WebServer) WebAuth(rw http.ResponseWriter, req *http.Request) error {
-	_, passwd, ok := req.BasicAuth()
+	user, passwd, ok := req.BasicAuth()
 	if !ok {
 		err := NeedAuth(rw, HTTP_401)
 		log.Debug(err)
 		return errors.New("Need Authorization")
 	}
 
-	if passwd != cnfg.AdminPass {
+	// Tracked apart from proxy logins, so a ban can still be lifted.
+	// The name is tracked too, against guessing from many addresses.
+	keys := []string{"admin:" + clientIP(req), "admin-user:" + user}
+	if ws.s.lockedOut(keys...) {
+		NeedAuth(rw, HTTP_401)
+		return errors.New(req.RemoteAddr + " is locked out")
+	}
+	// Names without an account log in with the admin password.
+	a, ok := ws.s.cnfg.Admins[user]
+	if !ok {
+		a = config.Admin{Password: ws.s.cnfg.AdminPass, Role: config.RoleAdmin}
+	}
+	if a.Password == "" || !verifyPassword(a.Password, passwd) {
+		ws.s.loginFailed(keys...)
 		NeedAuth(rw, HTTP_401)
 		return errors.New(req.RemoteAddr + "Fail to log in")
 	}
+	loginSucceeded(keys...)
+	ws.Admin, ws.role = user, a
 	return nil
 }
 
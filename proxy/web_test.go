@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"httpproxy/config"
+)
+
+func TestWebRoles(t *testing.T) {
+	useConfig(t, config.Config{
+		AdminPass: "root",
+		Admins: map[string]config.Admin{
+			"vera": {Password: "v", Role: config.RoleViewer},
+			"ursa": {Password: "u", Role: config.RoleUserManager},
+			"ada":  {Password: "a", Role: config.RoleAdmin},
+		},
+	})
+	// Each of these gets past the role check and then fails without
+	// changing anything, with a status other than 403.
+	tests := []struct {
+		user, passwd string
+		method, path string
+		status       int
+	}{
+		{"vera", "v", "POST", "/lockout/lift/ip:192.0.2.99", http.StatusForbidden},
+		{"ursa", "u", "POST", "/lockout/lift/ip:192.0.2.99", http.StatusOK},
+		{"ursa", "u", "POST", "/errorpage/save?text={{.Nothing}}", http.StatusForbidden},
+		{"ada", "a", "POST", "/errorpage/save?text={{.Nothing}}", http.StatusInternalServerError},
+		{"vera", "v", "GET", "/reload", http.StatusMethodNotAllowed},
+		{"vera", "v", "POST", "/reload", http.StatusForbidden},
+		{"ursa", "u", "POST", "/reload", http.StatusForbidden},
+		// Names without an account use the admin password.
+		{"someone", "root", "POST", "/errorpage/save?text={{.Nothing}}", http.StatusInternalServerError},
+		{"vera", "root", "GET", "/reload", http.StatusUnauthorized},
+		{"ada", "wrong", "GET", "/reload", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		req.SetBasicAuth(tt.user, tt.passwd)
+		rw := httptest.NewRecorder()
+		NewWebServer().ServeHTTP(rw, req)
+		if rw.Code != tt.status {
+			t.Errorf("%s: %s %s: status %d, want %d", tt.user, tt.method, tt.path, rw.Code, tt.status)
+		}
+	}
+}
+
+func TestWebDotDot(t *testing.T) {
+	useConfig(t, config.Config{AdminPass: "root"})
+	for _, path := range []string{
+		"/static/../config/config.json",
+		"/static/..%2f..%2fetc/passwd",
+		"/static/css/../../views/layout.tpl",
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.URL.Path = strings.ReplaceAll(path, "%2f", "/")
+		req.SetBasicAuth("admin", "root")
+		rw := httptest.NewRecorder()
+		NewWebServer().ServeHTTP(rw, req)
+		if rw.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, rw.Code)
+		}
+	}
+}
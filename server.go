@@ -1,11 +1,14 @@
 package main
 
 import (
+	"errors"
 	"os"
 	"os/signal"
+	"sync"
     "syscall"
 	"log"
 	"flag"
+	"net"
 	"net/http"
 
 	"httpproxy/proxy"
@@ -16,6 +19,13 @@ var (
 	cnfg config.Config
 )
 
+// serve runs srv on ln until ln is closed.
+func serve(srv *http.Server, ln net.Listener) {
+	if err := srv.Serve(ln); !errors.Is(err, net.ErrClosed) && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
+
 func main() {
 	// Parse arguments
 	configPtr := flag.String("c", "config/config.json", "config file")
@@ -27,24 +37,55 @@ func main() {
 	if err := cnfg.GetConfig(); err != nil {
 		log.Fatal(err)
 	}
+	if err := cnfg.Check(); err != nil {
+		log.Fatal(err)
+	}
+	if err := cnfg.SaveRehashed(); err != nil {
+		log.Fatal(err)
+	}
 	proxy.Initialize(cnfg)
 	pxy := proxy.NewProxyServer()
-	web := proxy.NewWebServer()
+	web := &http.Server{Handler: proxy.NewWebServer()}
 	pln := proxy.NewProxyListener()
 	wln := proxy.NewWebListener()
 
+	// A reload may move the listeners. Connections already accepted
+	// are not affected by closing the old ones.
+	var mu sync.Mutex
+	proxy.OnReload(func(proxyLn, webLn net.Listener) {
+		mu.Lock()
+		defer mu.Unlock()
+		if proxyLn != nil {
+			go serve(pxy, proxyLn)
+			pln.Close()
+			pln = proxyLn
+		}
+		if webLn != nil {
+			go serve(web, webLn)
+			wln.Close()
+			wln = webLn
+		}
+	})
+
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<- sigs
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	go serve(web, wln)
+	go serve(pxy, pln)
+	log.Println("begin proxy")
+
+	for sig := range sigs {
+		if sig == syscall.SIGHUP {
+			// Errors are logged by Reload, the old config stays.
+			proxy.Reload()
+			continue
+		}
+		mu.Lock()
+		proxy.FlushTokens()
 		pxy.Close()
 		pln.Close()
 		wln.Close()
 		log.Println("Close socket")
 		os.Exit(0)
-	}()
-
-	go http.Serve(wln, web)
-	log.Println("begin proxy")
-	log.Fatal(pxy.Serve(pln))
+	}
 }
@@ -8,6 +8,8 @@ export PROXY_AUTHORIZATION=${PROXY_AUTHORIZATION:-true}
 export LOG_LEVEL=${LOG_LEVEL:-0}
 export ADMIN_PASSWORD=${ADMIN_PASSWORD:-proxy}
 export PROXY_USER=${PROXY_USER:-{}}
+# Accept WebSocket over HTTP/2 (extended CONNECT)
+export GODEBUG=${GODEBUG:-http2xconnect=1}
 
 envsubst < config.template > config.json
 exec ./server -c config.json
\ No newline at end of file
@@ -0,0 +1,116 @@
+{{define "content"}}
+<h1 class="compact">带宽限制</h1>
+<span>[字节/秒，0为不限制]</span>
+<form accept-charset="UTF-8" class="bandwidth">
+	<input type="hidden" name="scope" value="global" />
+	<div id="field">
+	<label>全局上传</label>
+	<br />
+	<input type="text" pattern="[0-9]+" name="upload" value="{{.Bandwidth.Upload}}" size="30" />
+	</div>
+	<div id="field">
+	<label>全局下载</label>
+	<br />
+	<input type="text" pattern="[0-9]+" name="download" value="{{.Bandwidth.Download}}" size="30" />
+	</div>
+	<div class="actions"><input type="submit" value="设置" /></div>
+</form>
+<h2>用户</h2>
+<table class="userlist">
+		<thead>
+		<tr>
+		    <th class="header">用户名</th>
+		    <th class="header">上传</th>
+		    <th class="header">下载</th>
+		    <th class="header">操作</th>
+		</tr>
+		</thead>
+		<tbody>
+		{{range $user, $profile := .Profiles}}
+		<tr>
+			<td>{{$user}}</td>
+			<td>{{$profile.Bandwidth.Upload}}</td>
+			<td>{{$profile.Bandwidth.Download}}</td>
+			<td><a href="#" class="delete" data-scope="user" data-name="{{$user}}">删除</a></td>
+		</tr>
+		{{end}}
+		<tr>
+		<form accept-charset="UTF-8" class="bandwidth">
+			<input type="hidden" name="scope" value="user" />
+			<td><input type="text" name="name" placeholder="用户名，*为默认" required /></td>
+			<td><input type="text" pattern="[0-9]+" name="upload" required /></td>
+			<td><input type="text" pattern="[0-9]+" name="download" required /></td>
+			<td><div class="actions"><input type="submit" value="设置" /></div></td>
+		</form>
+		</tr>
+	</tbody>
+</table>
+<h2>域名</h2>
+<table class="userlist">
+		<thead>
+		<tr>
+		    <th class="header">域名</th>
+		    <th class="header">上传</th>
+		    <th class="header">下载</th>
+		    <th class="header">操作</th>
+		</tr>
+		</thead>
+		<tbody>
+		{{range $domain, $bw := .DomainBandwidth}}
+		<tr>
+			<td>{{$domain}}</td>
+			<td>{{$bw.Upload}}</td>
+			<td>{{$bw.Download}}</td>
+			<td><a href="#" class="delete" data-scope="domain" data-name="{{$domain}}">删除</a></td>
+		</tr>
+		{{end}}
+		<tr>
+		<form accept-charset="UTF-8" class="bandwidth">
+			<input type="hidden" name="scope" value="domain" />
+			<td><input type="text" name="name" placeholder="example.com" required /></td>
+			<td><input type="text" pattern="[0-9]+" name="upload" required /></td>
+			<td><input type="text" pattern="[0-9]+" name="download" required /></td>
+			<td><div class="actions"><input type="submit" value="设置" /></div></td>
+		</form>
+		</tr>
+	</tbody>
+</table>
+<script type="text/javascript">
+	$(document).ready(function(){
+		$(".userlist tr:even").addClass("even");
+	});
+</script>
+<script type="text/javascript">
+	$('form.bandwidth').submit( function(e) {
+		e.preventDefault();
+		$.ajax({
+			type:'POST',
+			url:'/bandwidth/set',
+			data:$(this).serialize(),
+			error: function(response) {
+				alert('failed')
+			},
+			success: function() {
+				window.location.reload()
+			}
+		});
+	});
+	$('a.delete').click( function(e) {
+		e.preventDefault();
+		if (!confirm('delete '+$(this).data('name')+'?')) {
+			return;
+		}
+		$.ajax({
+			type:'POST',
+			url:'/bandwidth/delete',
+			data:{scope:$(this).data('scope'), name:$(this).data('name')},
+			error: function() {
+				alert('failed!');
+			},
+			success: function() {
+				window.location.reload()
+			}
+		});
+	});
+</script>
+{{end}}
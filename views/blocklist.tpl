@@ -0,0 +1,50 @@
+{{define "content"}}
+<h1 class="compact">订阅黑名单</h1>
+<div class="notice">订阅列表在配置文件 blocklists 中设置，最近一次成功下载的副本保存在 {{if .BlocklistDir}}{{.BlocklistDir}}{{else}}config/blocklists{{end}}</div>
+<table class="userlist">
+		<thead>
+		<tr>
+		    <th class="header">名称</th>
+		    <th class="header">地址</th>
+		    <th class="header">格式</th>
+		    <th class="header">条目数</th>
+		    <th class="header">更新时间</th>
+		    <th class="header">检查时间</th>
+		    <th class="header">错误</th>
+		    <th class="header">操作</th>
+		</tr>
+		</thead>
+		<tbody>
+		{{range .Lists}}
+		<tr>
+			<td>{{.Name}}{{if .Allow}} [白名单]{{end}}</td>
+			<td>{{.URL}}</td>
+			<td>{{.Format}}</td>
+			<td>{{.Entries}}</td>
+			<td>{{if .Updated.IsZero}}-{{else}}{{.Updated.Format "2006-01-02 15:04:05"}}{{end}}</td>
+			<td>{{if .Checked.IsZero}}-{{else}}{{.Checked.Format "2006-01-02 15:04:05"}}{{end}}</td>
+			<td>{{.Error}}</td>
+			<td><button class="refresh" data-name="{{.Name}}">刷新</button></td>
+		</tr>
+		{{end}}
+	</tbody>
+</table>
+<script type="text/javascript">
+	$(document).ready(function(){
+		$(".userlist tr:even").addClass("even");
+	});
+	$('.refresh').on('click', function() {
+		var name = $(this).data('name')
+		$.ajax({
+			type:'POST',
+			url:'/blocklist/refresh/'+encodeURIComponent(name),
+			error: function(response) {
+				alert(response.responseText || 'failed!');
+			},
+			success: function() {
+				window.location.reload();
+			}
+		});
+	});
+</script>
+{{end}}
@@ -0,0 +1,26 @@
+{{define "content"}}
+<h1 class="compact">错误页面</h1>
+<span>[所有错误页面共用的html/template模板，可用 .Status .StatusText .Kind .Title .Message .User .Target .Rule .RequestID .Time；.Kind为{{range $i, $k := .Kinds}}{{if $i}}、{{end}}{{$k}}{{end}}；非浏览器客户端收到JSON]</span>
+<form accept-charset="UTF-8" id="error_page">
+	<div id="field">
+	<textarea name="text" rows="30" cols="100">{{.Text}}</textarea>
+	</div>
+	<div class="actions"><input type="submit" value="保存" /></div>
+</form>
+<script type="text/javascript">
+	$('#error_page').submit( function(e) {
+		e.preventDefault();
+		$.ajax({
+			type:'POST',
+			url:'/errorpage/save',
+			data:$(this).serialize(),
+			error: function(response) {
+				alert(response.responseText);
+			},
+			success: function() {
+				window.location.reload()
+			}
+		});
+	});
+</script>
+{{end}}
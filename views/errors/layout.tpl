@@ -0,0 +1,26 @@
+<!DOCTYPE html>
+<html>
+<head>
+	<meta http-equiv="Content-Type" content="text/html; charset=UTF-8" />
+	<title>{{.Status}} {{.Title}}</title>
+	<style>
+	body { width: 40em; margin: 4em auto; font-family: Tahoma, Verdana, Arial, sans-serif; color: #333; }
+	h1 { font-size: 1.6em; }
+	table { border-collapse: collapse; }
+	td { padding: 0.2em 1em 0.2em 0; }
+	.muted { color: #888; font-size: 0.9em; }
+	</style>
+</head>
+<body>
+	<h1>{{.Title}}</h1>
+	<p>{{.Message}}</p>
+	<table>
+		{{if .User}}<tr><td>用户</td><td>{{.User}}</td></tr>{{end}}
+		<tr><td>目标</td><td>{{.Target}}</td></tr>
+		{{if .Rule}}<tr><td>规则</td><td>{{.Rule}}</td></tr>{{end}}
+		<tr><td>请求编号</td><td>{{.RequestID}}</td></tr>
+		<tr><td>时间</td><td>{{.Time.Format "2006-01-02 15:04:05"}}</td></tr>
+	</table>
+	<p class="muted">{{.Status}} {{.StatusText}}，如有疑问请将请求编号提供给管理员</p>
+</body>
+</html>
@@ -11,12 +11,20 @@
  	<div class="container">
  		<div id="pageHeader">
 		<h1>Http Proxy Web</h1> 		
+		<span>当前登入：{{.Admin}} ({{if eq .Role "viewer"}}只读{{else if eq .Role "user_manager"}}用户管理员{{else}}管理员{{end}})</span>
  		</div>
 
     <div id="navibar" class="span-3 last">
         <ul>
           <li>{{if eq .Nav "home"}}<span>主页</span>{{else}}<a href="/">主页</a>{{end}}</li>
           <li>{{if eq .Nav "user"}}<span>用户</span>{{else}}<a href="/user/list/detail">用户</a>{{end}}</li>
+          <li>{{if eq .Nav "upstream"}}<span>上游</span>{{else}}<a href="/upstream">上游</a>{{end}}</li>
+          <li>{{if eq .Nav "bandwidth"}}<span>带宽</span>{{else}}<a href="/bandwidth/list">带宽</a>{{end}}</li>
+          <li>{{if eq .Nav "limit"}}<span>并发</span>{{else}}<a href="/limit">并发</a>{{end}}</li>
+          <li>{{if eq .Nav "token"}}<span>令牌</span>{{else}}<a href="/token/list">令牌</a>{{end}}</li>
+          <li>{{if eq .Nav "lockout"}}<span>封禁</span>{{else}}<a href="/lockout">封禁</a>{{end}}</li>
+          <li>{{if eq .Nav "blocklist"}}<span>订阅</span>{{else}}<a href="/blocklist">订阅</a>{{end}}</li>
+          <li>{{if eq .Nav "errorpage"}}<span>错误页</span>{{else}}<a href="/errorpage">错误页</a>{{end}}</li>
           <li>{{if eq .Nav "setting"}}<span>设置</span>{{else}}<a href="/setting/list">设置</a>{{end}}</li>
         </ul>
       </div>
@@ -0,0 +1,27 @@
+{{define "content"}}
+<h1 class="compact">并发与请求频率</h1>
+<div class="notice">每个IP限制: 隧道 {{.IPLimits.Tunnels}}，请求 {{.IPLimits.Requests}}，每秒 {{.IPLimits.RPS}} [0为不限制]</div>
+<table class="userlist">
+		<thead>
+		<tr>
+		    <th class="header">用户/IP</th>
+		    <th class="header">隧道</th>
+		    <th class="header">请求</th>
+		</tr>
+		</thead>
+		<tbody>
+		{{range .Counters}}
+		<tr>
+			<td>{{.Key}}</td>
+			<td>{{.Tunnels}}</td>
+			<td>{{.Requests}}</td>
+		</tr>
+		{{end}}
+	</tbody>
+</table>
+<script type="text/javascript">
+	$(document).ready(function(){
+		$(".userlist tr:even").addClass("even");
+	});
+</script>
+{{end}}
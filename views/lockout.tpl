@@ -0,0 +1,46 @@
+{{define "content"}}
+<h1 class="compact">登录失败与封禁</h1>
+<div class="notice">失败 {{.Lockout.Threshold}} 次后封禁 [0为不封禁]，封禁时长 {{.Lockout.Duration}} 秒起，每次翻倍，最长 {{.Lockout.MaxDuration}} 秒</div>
+<table class="userlist">
+		<thead>
+		<tr>
+		    <th class="header">IP/用户</th>
+		    <th class="header">失败次数</th>
+		    <th class="header">封禁次数</th>
+		    <th class="header">封禁至</th>
+		    <th class="header">操作</th>
+		</tr>
+		</thead>
+		<tbody>
+		{{range .Bans}}
+		<tr>
+			<td>{{.Key}}</td>
+			<td>{{.Failures}}</td>
+			<td>{{.Bans}}</td>
+			<td>{{if .Banned}}{{.BannedUntil.Format "2006-01-02 15:04:05"}}{{else}}-{{end}}</td>
+			<td><button class="lift" data-key="{{.Key}}">解除</button></td>
+		</tr>
+		{{end}}
+	</tbody>
+</table>
+<script type="text/javascript">
+	$(document).ready(function(){
+		$(".userlist tr:even").addClass("even");
+	});
+	$('.lift').on('click', function() {
+		var key = $(this).data('key')
+		if (confirm('lift the ban of '+key+'?')) {
+			$.ajax({
+				type:'POST',
+				url:'/lockout/lift/'+encodeURIComponent(key),
+				error: function() {
+					alert('failed!');
+				},
+				success: function() {
+					window.location.reload();
+				}
+			});
+		}
+	});
+</script>
+{{end}}
@@ -37,9 +37,10 @@
 	<input type="text" pattern="[0-9]+" id="cachetimeout" name="cachetimeout" value="{{.CacheTimeout}}" size="30" />
 	</div>
 	<div id="field">
-	<label for="gfwlist">网站过滤列表</label><span> [关键词英文分号分割]</span>
+	<label for="gfwlist">网站过滤列表</label><span> [每行一条规则，如 example.com、@@||a.example.com、10.0.0.0/8、:25、example.org$schedule=mon-fri 09:00-18:00; sat 10:00-12:00]</span>
 	<br />
-	<input type="text" id="gfwlist" name="gfwlist" {{if eq (len .GFWList) 0}}{{else}}value="{{range .GFWList}}{{.}};{{end}}"{{end}} size="30" />
+	<textarea id="gfwlist" name="gfwlist" rows="6" cols="60">{{range .GFWList}}{{.}}
+{{end}}</textarea>
 	</div>
 	<div id="field">
 	<label for="log">日志</label><span> [1 调试模式/0 普通模式]</span>
@@ -48,6 +49,9 @@
 	</div>
 	<div class="actions"><input type="submit" value="设置" /></div>
 </form>
+<form accept-charset="UTF-8" id="reload">
+	<div class="actions"><input type="submit" value="重新加载配置文件" /></div>
+</form>
 </script>
 <script type="text/javascript">
 	$('#new_config').submit( function(e) {
@@ -56,12 +60,25 @@
 			url:'/setting/set',
 			data:$(this).serialize(),
 			error: function(response) {
-				alert("failed");
+				alert(response.responseText);
             },
 			success: function() {
 				window.location.reload()
 			}
 		});
 	});
+	$('#reload').submit( function(e) {
+		e.preventDefault();
+		$.ajax({
+			type:'POST',
+			url:'/reload/',
+			error: function(response) {
+				alert(response.responseText);
+			},
+			success: function() {
+				window.location.reload()
+			}
+		});
+	});
 	</script>
 {{end}}
\ No newline at end of file
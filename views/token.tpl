@@ -0,0 +1,78 @@
+{{define "content"}}
+<h1 class="compact">访问令牌</h1>
+<span>[令牌可作为Basic认证的密码，或以 Proxy-Authorization: Bearer 发送；只在签发时显示一次]</span>
+<table class="userlist">
+		<thead>
+		<tr>
+		    <th class="header">编号</th>
+		    <th class="header">用户名</th>
+		    <th class="header">备注</th>
+		    <th class="header">过期时间</th>
+		    <th class="header">允许域名</th>
+		    <th class="header">已用/配额(字节)</th>
+		    <th class="header">操作</th>
+		</tr>
+		</thead>
+		<tbody>
+		{{range .Tokens}}
+		<tr>
+			<td>{{.ID}}</td>
+			<td>{{.User}}</td>
+			<td>{{.Note}}</td>
+			<td>{{if .Expires.IsZero}}永不{{else}}{{.Expires.Format "2006-01-02 15:04:05"}}{{end}}</td>
+			<td>{{range .Domains}}{{.}} {{else}}不限{{end}}</td>
+			<td>{{.Used}}/{{if .Quota}}{{.Quota}}{{else}}不限{{end}}</td>
+			<td><a href="#" class="revoke" data-id="{{.ID}}">撤销</a></td>
+		</tr>
+		{{end}}
+		<tr>
+		<form accept-charset="UTF-8" id="new_token">
+			<td></td>
+			<td><input type="text" name="user" placeholder="用户名" required /></td>
+			<td><input type="text" name="note" /></td>
+			<td><input type="text" pattern="[0-9]*" name="hours" placeholder="有效小时数，空为永不" /></td>
+			<td><input type="text" name="domains" placeholder="英文分号分割，空为不限" /></td>
+			<td><input type="text" pattern="[0-9]*" name="quota" placeholder="字节，空为不限" /></td>
+			<td><div class="actions"><input type="submit" value="签发" /></div></td>
+		</form>
+		</tr>
+	</tbody>
+</table>
+<script type="text/javascript">
+	$(document).ready(function(){
+		$(".userlist tr:even").addClass("even");
+	});
+	$('#new_token').submit( function(e) {
+		e.preventDefault();
+		$.ajax({
+			type:'POST',
+			url:'/token/issue',
+			data:$(this).serialize(),
+			error: function(response) {
+				alert(response.responseText);
+			},
+			success: function(token) {
+				prompt('令牌只显示这一次，请复制保存', token);
+				window.location.reload()
+			}
+		});
+	});
+	$('a.revoke').click( function(e) {
+		e.preventDefault();
+		var id = $(this).data('id')
+		if (!confirm('revoke token '+id+'?')) {
+			return;
+		}
+		$.ajax({
+			type:'POST',
+			url:'/token/revoke/'+id,
+			error: function() {
+				alert('failed!');
+			},
+			success: function() {
+				window.location.reload()
+			}
+		});
+	});
+</script>
+{{end}}
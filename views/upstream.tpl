@@ -0,0 +1,33 @@
+{{define "content"}}
+<h1 class="compact">上游服务器</h1>
+{{range .Pools}}
+<h2>{{.Name}} <span>[{{.Strategy}}]</span></h2>
+<table class="userlist">
+		<thead>
+		<tr>
+		    <th class="header">地址</th>
+		    <th class="header">权重</th>
+		    <th class="header">状态</th>
+		    <th class="header">活动请求</th>
+		</tr>
+		</thead>
+		<tbody>
+		{{range .Members}}
+		<tr>
+			<td>{{.Addr}}</td>
+			<td>{{.Weight}}</td>
+			<td>{{if .Healthy}}正常{{else}}已剔除{{end}}</td>
+			<td>{{.Active}}</td>
+		</tr>
+		{{end}}
+	</tbody>
+</table>
+{{else}}
+<div class="notice">没有配置上游服务器组</div>
+{{end}}
+<script type="text/javascript">
+	$(document).ready(function(){
+		$(".userlist tr:even").addClass("even");
+	});
+</script>
+{{end}}
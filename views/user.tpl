@@ -13,7 +13,7 @@
 		{{range $user,$passwd :=.}}
 		<tr>
 			<td>{{$user}}</td>
-			<td><input type="text" id="passwd{{$user}}" name="passwd" value="{{$passwd}}" required /></td>
+			<td><input type="password" id="passwd{{$user}}" name="passwd" placeholder="新密码" required /></td>
 			<td><select name="action" data-id="{{$user}}">
 			<option selected value="">无</option>
 			<option value="delete">删除</option>
@@ -25,7 +25,7 @@
 		<tr>
 		<form accept-charset="UTF-8" id="new_user">
 			<td><input type="text" name="user" required /></td>
-			<td><input type="text" name="passwd" required /></td>
+			<td><input type="password" name="passwd" required /></td>
 			<td><div class="actions"><input type="submit" value="增加" /></div></td>
 		</form>
 		</tr>